@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Policies combining the results of the alternatives of a match request
+const (
+	policyPriority = "priority" // The hit in the highest priority level wins (default)
+	policyVote     = "vote"     // The result with the highest total confidence wins
+	policyAgree    = "agree"    // Every alternative must give the same result, otherwise "unknown"
+)
+
+// lowConfidenceMask replaces words below min_word_confidence in the text seen by
+// hardcoded categories; it is not a word character, so no keyword spans it
+const lowConfidenceMask = "\x00"
+
+// Alternative is one ASR hypothesis of an utterance
+type Alternative struct {
+	Text       string           `json:"text"`
+	Confidence *float64         `json:"confidence,omitempty"` // 0 to 1 (default: 1)
+	Words      []WordConfidence `json:"words,omitempty"`      // Per-word confidences, in text order
+}
+
+// WordConfidence is the ASR confidence of one word of an alternative
+type WordConfidence struct {
+	Word       string  `json:"word"`
+	Confidence float64 `json:"confidence"`
+}
+
+// AlternativeResult is the result of one alternative in a MatchResponse
+type AlternativeResult struct {
+	Index       int     `json:"index"`
+	Result      string  `json:"result"`
+	CategoryKey string  `json:"category_key,omitempty"`
+	Confidence  float64 `json:"confidence"`
+}
+
+// alternativesDecision is the combined result of the alternatives of a request
+type alternativesDecision struct {
+	result      *matchResult        // nil: "unknown"
+	alternative int                 // Index of the alternative that produced the decision (-1: none)
+	results     []AlternativeResult // One per alternative, in request order
+}
+
+// validateAlternatives checks the alternatives of a match request and fills in
+// the defaults: the policy, and speech_text from the first alternative
+func validateAlternatives(req *MatchRequest) string {
+	switch req.Policy {
+	case "":
+		req.Policy = policyPriority
+	case policyPriority, policyVote, policyAgree:
+	default:
+		return fmt.Sprintf("Invalid policy: %s (must be %s, %s or %s)", req.Policy, policyPriority, policyVote, policyAgree)
+	}
+	if req.MinWordConfidence < 0 || req.MinWordConfidence > 1 {
+		return "min_word_confidence must be between 0 and 1"
+	}
+
+	for i, alt := range req.Alternatives {
+		if alt.text() == "" {
+			return fmt.Sprintf("alternatives[%d] has no text", i)
+		}
+		if alt.Confidence != nil && (*alt.Confidence < 0 || *alt.Confidence > 1) {
+			return fmt.Sprintf("alternatives[%d].confidence must be between 0 and 1", i)
+		}
+	}
+
+	if req.SpeechText == "" {
+		req.SpeechText = req.Alternatives[0].text()
+	}
+	return ""
+}
+
+// text returns the text of the alternative, built from its words if it has none
+func (alt *Alternative) text() string {
+	if alt.Text != "" || len(alt.Words) == 0 {
+		return strings.TrimSpace(alt.Text)
+	}
+	words := make([]string, 0, len(alt.Words))
+	for _, w := range alt.Words {
+		words = append(words, w.Word)
+	}
+	return strings.TrimSpace(strings.Join(words, " "))
+}
+
+// weight is the confidence of the alternative, 1 when the ASR gave none
+func (alt *Alternative) weight() float64 {
+	if alt.Confidence == nil {
+		return 1
+	}
+	return *alt.Confidence
+}
+
+// maskedText returns the text of the alternative with the words below
+// minConfidence masked, or its text if no word is masked. Masked text is built
+// from the words, which may differ from the text (e.g. in punctuation).
+func (alt *Alternative) maskedText(minConfidence float64) string {
+	if minConfidence <= 0 {
+		return alt.text()
+	}
+	words := make([]string, 0, len(alt.Words))
+	masked := false
+	for _, w := range alt.Words {
+		if w.Confidence < minConfidence {
+			words = append(words, lowConfidenceMask)
+			masked = true
+		} else {
+			words = append(words, w.Word)
+		}
+	}
+	if !masked {
+		return alt.text()
+	}
+	return strings.Join(words, " ")
+}
+
+// matchAlternatives runs ProcessStage over every alternative of req and combines
+// the results with req.Policy. Hardcoded categories only see the words of an
+// alternative with a confidence of at least req.MinWordConfidence.
+// Only the winning keyword of the decision is counted in the keyword stats, once
+// however many alternatives agree on it.
+func (km *KeywordMatcher) matchAlternatives(req *MatchRequest) *alternativesDecision {
+	results := make([]*matchResult, len(req.Alternatives))
+	decision := &alternativesDecision{
+		alternative: -1,
+		results:     make([]AlternativeResult, len(req.Alternatives)),
+	}
+
+	for i := range req.Alternatives {
+		alt := &req.Alternatives[i]
+		results[i] = km.peekStageMasked(alt.text(), alt.maskedText(req.MinWordConfidence), req.Stage)
+
+		decision.results[i] = AlternativeResult{Index: i, Result: "unknown", Confidence: alt.weight()}
+		if results[i] != nil {
+			decision.results[i].Result = results[i].returnValue
+			decision.results[i].CategoryKey = results[i].categoryKey
+		}
+	}
+
+	switch req.Policy {
+	case policyVote:
+		decision.alternative = voteAlternatives(req.Alternatives, decision.results)
+	case policyAgree:
+		decision.alternative = 0
+		for _, r := range decision.results {
+			if r.Result != decision.results[0].Result {
+				decision.alternative = -1
+				break
+			}
+		}
+	default:
+		for i, result := range results {
+			if result == nil {
+				continue
+			}
+			if decision.alternative < 0 || outranks(result, results[decision.alternative]) ||
+				(sameRank(result, results[decision.alternative]) && req.Alternatives[i].weight() > req.Alternatives[decision.alternative].weight()) {
+				decision.alternative = i
+			}
+		}
+	}
+
+	if decision.alternative >= 0 {
+		decision.result = results[decision.alternative]
+		if decision.result == nil {
+			// The decision is "unknown"
+			decision.alternative = -1
+		} else {
+			km.stageMap[req.Stage].countMatch(decision.result, nil)
+		}
+	}
+	return decision
+}
+
+// voteAlternatives returns the alternative with the highest confidence among
+// those giving the result with the highest total confidence
+// Ties go to the earlier alternative.
+func voteAlternatives(alternatives []Alternative, results []AlternativeResult) int {
+	totals := make(map[string]float64)
+	for i, r := range results {
+		totals[r.Result] += alternatives[i].weight()
+	}
+
+	best := -1
+	for i, r := range results {
+		if best < 0 || totals[r.Result] > totals[results[best].Result] ||
+			(r.Result == results[best].Result && alternatives[i].weight() > alternatives[best].weight()) {
+			best = i
+		}
+	}
+	return best
+}
+
+// outranks reports whether a comes from a higher priority level than b
+// (hardcoded categories first, then p1, p2, p3, etc.)
+func outranks(a, b *matchResult) bool {
+	if a.hardcoded != b.hardcoded {
+		return a.hardcoded
+	}
+	return !a.hardcoded && a.priority < b.priority
+}
+
+// sameRank reports whether a and b come from the same priority level
+func sameRank(a, b *matchResult) bool {
+	return !outranks(a, b) && !outranks(b, a)
+}
+
+// peekStageMasked is peekStage where hardcoded categories are matched against
+// masked instead of text
+func (km *KeywordMatcher) peekStageMasked(text, masked, stage string) *matchResult {
+	if masked == text {
+		return km.peekStage(text, stage)
+	}
+	stageData, exists := km.stageMap[stage]
+	if !exists {
+		return nil
+	}
+
+	normalized := km.normalizeText(text)
+	hits := stageData.scan(normalized)
+	maskedNormalized := km.normalizeText(masked)
+	maskedHits := stageData.scan(maskedNormalized)
+
+	// Scores count competing categories over every hit of the stage, as in
+	// findStageMatch, so masking does not change the score of a match
+	for i := range stageData.groups {
+		group := &stageData.groups[i]
+		groupText, stageHits := normalized, hits
+		if group.hardcoded {
+			groupText, stageHits = maskedNormalized, maskedHits
+		}
+		if result := km.findBestMatch(groupText, stageData, stageData.groupHits(stageHits, group)); result != nil {
+			km.applyConfidence(stageData, groupText, result, stageHits)
+			return result
+		}
+	}
+	return nil
+}
+
+// matchAlternativesObserved runs matchAlternatives for a request, records its
+// metrics and captures the alternative that produced the decision (or the
+// first one if the decision is "unknown")
+func matchAlternativesObserved(matcher *KeywordMatcher, req *MatchRequest) *alternativesDecision {
+	start := time.Now()
+	decision := matcher.matchAlternatives(req)
+	text := req.Alternatives[0].text()
+	if decision.alternative >= 0 {
+		text = req.Alternatives[decision.alternative].text()
+	}
+	observeMatch(matcher, req.Campaign, req.Stage, text, decision.result, time.Since(start))
+	captureAlternativesMatch(matcher, req, text, decision.result)
+	return decision
+}
+
+// returnValue is the result of the decision
+func (d *alternativesDecision) returnValue() string {
+	return returnValueOf(d.result)
+}
+
+// alternativeIndex is the index of the deciding alternative for a response, nil if none
+func (d *alternativesDecision) alternativeIndex() *int {
+	if d.alternative < 0 {
+		return nil
+	}
+	index := d.alternative
+	return &index
+}
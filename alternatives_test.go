@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+// Masking low-confidence words for hardcoded categories must not change how a
+// match is scored: competing categories are counted over the whole stage
+func TestPeekStageMaskedScore(t *testing.T) {
+	km := newTestMatcher(t, `{
+		"doNotCall_hardcoded_s1": ["do not call"],
+		"interested_p1_s1": ["fine"],
+		"busy_p2_s1": ["busy"],
+		"later_p3_s1": ["later"]
+	}`)
+
+	tests := []struct {
+		text, masked, result string
+	}{
+		{"fine thanks but busy later", "fine \x00 but busy later", "interested"},
+		{"do not call me busy later", "do not call \x00 busy later", "donotcall"},
+	}
+	for _, tt := range tests {
+		masked := km.peekStageMasked(tt.text, tt.masked, "s1")
+		plain := km.peekStage(tt.text, "s1")
+		if masked == nil || plain == nil || masked.returnValue != tt.result || plain.returnValue != tt.result {
+			t.Fatalf("%q: masked %+v, plain %+v, want %s", tt.text, masked, plain, tt.result)
+		}
+		if masked.score != plain.score {
+			t.Errorf("%q: masked score %v, plain score %v", tt.text, masked.score, plain.score)
+		}
+	}
+}
+
+// Words above the confidence threshold leave the alternative's own text, with
+// its punctuation, to be matched
+func TestMaskedTextKeepsTextWhenNothingIsMasked(t *testing.T) {
+	alt := Alternative{
+		Text:  "Do not, call me.",
+		Words: []WordConfidence{{"do", 0.9}, {"not", 0.9}, {"call", 0.8}, {"me", 0.95}},
+	}
+	if got := alt.maskedText(0.5); got != alt.text() {
+		t.Errorf("maskedText = %q, want the text %q", got, alt.text())
+	}
+	if got, want := alt.maskedText(0.85), "do not "+lowConfidenceMask+" me"; got != want {
+		t.Errorf("maskedText = %q, want %q", got, want)
+	}
+}
+
+// A decision counts one win for its keyword, whatever the policy and however
+// many alternatives agree on it
+func TestMatchAlternativesCountsOnce(t *testing.T) {
+	confidence := func(c float64) *float64 { return &c }
+	tests := []struct {
+		policy       string
+		alternatives []Alternative
+		matches      uint64
+	}{
+		{policyPriority, []Alternative{{Text: "i am busy"}, {Text: "busy"}, {Text: "so busy"}}, 1},
+		{policyVote, []Alternative{{Text: "i am busy", Confidence: confidence(0.5)}, {Text: "busy", Confidence: confidence(0.4)}, {Text: "later", Confidence: confidence(0.3)}}, 1},
+		{policyAgree, []Alternative{{Text: "i am busy"}, {Text: "busy"}}, 1},
+		{policyAgree, []Alternative{{Text: "i am busy"}, {Text: "later"}}, 0},
+	}
+	for _, tt := range tests {
+		km := newTestMatcher(t, `{"busy_p1_s1": ["busy"], "later_p2_s1": ["later"]}`)
+		km.matchAlternatives(&MatchRequest{Stage: "s1", Policy: tt.policy, Alternatives: tt.alternatives})
+
+		stats := km.keywordStats(keywordStatsOptions{})
+		if stats.Matches != tt.matches {
+			t.Errorf("%s over %d alternatives counted %d matches, want %d", tt.policy, len(tt.alternatives), stats.Matches, tt.matches)
+		}
+		for _, category := range stats.Categories {
+			for _, kw := range category.Keywords {
+				if kw.Shadowed != 0 {
+					t.Errorf("%s: %s counted %d shadowed hits, want none", tt.policy, kw.Keyword, kw.Shadowed)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Roles of an API key; admin keys can also call every match route and /metrics.
+// Metrics keys can only scrape /metrics, so a Prometheus server does not need
+// a key that can reload or edit campaigns.
+const (
+	roleMatch   = "match"
+	roleAdmin   = "admin"
+	roleMetrics = "metrics"
+)
+
+// Request headers used for authentication
+const (
+	headerAPIKey    = "X-API-Key"
+	headerTimestamp = "X-Timestamp"
+	headerSignature = "X-Signature"
+	headerNonce     = "X-Nonce"
+)
+
+// maxSignatureSkew is how far X-Timestamp may be from the server clock
+const maxSignatureSkew = 5 * time.Minute
+
+// Limits of the nonces of signed requests: a nonce is remembered for as long as
+// its timestamp is accepted, and at most maxNonces are remembered at once
+const (
+	maxNonceLength = 128
+	maxNonces      = 1 << 20
+)
+
+// maxSignedBodyBytes limits the body read into memory to verify a signature
+const maxSignedBodyBytes = 32 << 20
+
+// authContextKey stores the authenticated *APIKey in the echo context
+const authContextKey = "auth_key"
+
+// AuthConfig is the content of the file named by AUTH_KEYS_FILE
+// Example:
+//
+//	{
+//	  "keys": [
+//	    {"id": "dialer", "key": "...", "role": "match", "campaigns": ["fe_basic"]},
+//	    {"id": "signed-dialer", "key": "...", "role": "match", "hmac_secret": "..."},
+//	    {"id": "ops", "key": "...", "role": "admin"},
+//	    {"id": "prometheus", "key": "...", "role": "metrics"}
+//	  ]
+//	}
+type AuthConfig struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// APIKey is one client credential
+// Requests send the key in X-API-Key (or "Authorization: Bearer <key>"). Keys
+// with an HMAC secret must also sign every request (see verifySignature).
+type APIKey struct {
+	ID         string   `json:"id"`
+	Key        string   `json:"key"`
+	Role       string   `json:"role"`        // "match", "admin" or "metrics"
+	Campaigns  []string `json:"campaigns"`   // Allowed campaigns (default: all)
+	HMACSecret string   `json:"hmac_secret"` // Requires signed requests when set
+}
+
+// authenticator checks API keys; a nil authenticator allows every request
+type authenticator struct {
+	keys   []APIKey
+	nonces *nonceCache
+}
+
+// nonceCache remembers the nonces of recent signed requests so that each
+// signed request is accepted only once
+type nonceCache struct {
+	sync.Mutex
+	seen      map[string]time.Time // Nonce (prefixed by the key ID) to when it can be forgotten
+	nextPrune time.Time
+}
+
+// use records nonce and returns an error message if it was already used, or ""
+// Nonces are forgotten once a request with them would fail the timestamp check anyway.
+func (nc *nonceCache) use(nonce string, now time.Time) string {
+	nc.Lock()
+	defer nc.Unlock()
+
+	if now.After(nc.nextPrune) {
+		for n, forget := range nc.seen {
+			if now.After(forget) {
+				delete(nc.seen, n)
+			}
+		}
+		nc.nextPrune = now.Add(time.Minute)
+	}
+
+	if forget, ok := nc.seen[nonce]; ok && !now.After(forget) {
+		return "Request was already used (X-Nonce)"
+	}
+	if len(nc.seen) >= maxNonces {
+		return "Too many signed requests, try again later"
+	}
+	nc.seen[nonce] = now.Add(2 * maxSignatureSkew)
+	return ""
+}
+
+// loadAuthenticator reads the keys file; an empty path disables authentication
+func loadAuthenticator(path string) (*authenticator, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth keys: %w", err)
+	}
+	var config AuthConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse auth keys: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for i, key := range config.Keys {
+		if key.ID == "" || key.Key == "" {
+			return nil, fmt.Errorf("auth key %d: id and key are required", i)
+		}
+		if key.Role != roleMatch && key.Role != roleAdmin && key.Role != roleMetrics {
+			return nil, fmt.Errorf("auth key %s: role must be %q, %q or %q", key.ID, roleMatch, roleAdmin, roleMetrics)
+		}
+		if seen[key.Key] {
+			return nil, fmt.Errorf("auth key %s: key is used twice", key.ID)
+		}
+		seen[key.Key] = true
+	}
+
+	return &authenticator{
+		keys:   config.Keys,
+		nonces: &nonceCache{seen: make(map[string]time.Time)},
+	}, nil
+}
+
+// lookup returns the key matching secret, comparing in constant time
+func (a *authenticator) lookup(secret string) *APIKey {
+	var found *APIKey
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(a.keys[i].Key), []byte(secret)) == 1 {
+			found = &a.keys[i]
+		}
+	}
+	return found
+}
+
+// hasRole reports whether the key may use routes that require role
+func (k *APIKey) hasRole(role string) bool {
+	return k.Role == roleAdmin || k.Role == role
+}
+
+// allowsCampaign reports whether the key may use campaign
+func (k *APIKey) allowsCampaign(campaign string) bool {
+	if len(k.Campaigns) == 0 {
+		return true
+	}
+	for _, allowed := range k.Campaigns {
+		if allowed == campaign {
+			return true
+		}
+	}
+	return false
+}
+
+// require returns middleware that authenticates the request and checks role.
+// A :campaign path parameter is checked against the key's allowlist; with
+// allCampaigns the key must not be restricted to some campaigns (for routes
+// that act on every campaign). Campaigns named in request bodies are checked by
+// the handlers with authorizeCampaign.
+func (a *authenticator) require(role string, allCampaigns bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if a == nil {
+				return next(c)
+			}
+
+			req := c.Request()
+			secret := req.Header.Get(headerAPIKey)
+			if secret == "" {
+				if bearer, ok := strings.CutPrefix(req.Header.Get(echo.HeaderAuthorization), "Bearer "); ok {
+					secret = strings.TrimSpace(bearer)
+				}
+			}
+			if secret == "" {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "API key required"})
+			}
+
+			key := a.lookup(secret)
+			if key == nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid API key"})
+			}
+			if key.HMACSecret != "" {
+				if msg := a.verifySignature(req, key); msg != "" {
+					return c.JSON(http.StatusUnauthorized, map[string]string{"error": msg})
+				}
+			}
+
+			if !key.hasRole(role) {
+				return c.JSON(http.StatusForbidden, map[string]string{
+					"error": fmt.Sprintf("API key %s does not have the %s role", key.ID, role),
+				})
+			}
+			if allCampaigns && len(key.Campaigns) > 0 {
+				return c.JSON(http.StatusForbidden, map[string]string{
+					"error": fmt.Sprintf("API key %s is restricted to some campaigns", key.ID),
+				})
+			}
+			if campaign := c.Param("campaign"); campaign != "" && !key.allowsCampaign(campaign) {
+				return c.JSON(http.StatusForbidden, map[string]string{
+					"error": fmt.Sprintf("API key %s is not allowed for campaign: %s", key.ID, campaign),
+				})
+			}
+
+			c.Set(authContextKey, key)
+			return next(c)
+		}
+	}
+}
+
+// verifySignature checks the HMAC of a request
+// The signature is the hex HMAC-SHA256, keyed with the key's secret, of
+//
+//	X-Timestamp + "\n" + X-Nonce + "\n" + method + "\n" + request URI + "\n" + body
+//
+// where X-Timestamp is the Unix time in seconds and X-Nonce a unique value of
+// at most maxNonceLength characters (e.g. a UUID). A nonce is accepted once per
+// key, so a captured request cannot be replayed against this server. Nonces are
+// kept in memory: with several replicas, a captured request can still be
+// replayed once on each other replica within maxSignatureSkew, so signed
+// clients should also use TLS. Returns an error message, or "" if the
+// signature is valid. The body is restored for the handler.
+func (a *authenticator) verifySignature(req *http.Request, key *APIKey) string {
+	timestamp := req.Header.Get(headerTimestamp)
+	nonce := req.Header.Get(headerNonce)
+	signature := req.Header.Get(headerSignature)
+	if timestamp == "" || nonce == "" || signature == "" {
+		return "Signed request required (X-Timestamp, X-Nonce and X-Signature headers)"
+	}
+	if len(nonce) > maxNonceLength {
+		return "Invalid X-Nonce"
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "Invalid X-Timestamp"
+	}
+	if skew := time.Since(time.Unix(seconds, 0)); skew > maxSignatureSkew || skew < -maxSignatureSkew {
+		return "Request timestamp is too old or in the future"
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(req.Body, maxSignedBodyBytes+1))
+		req.Body.Close()
+		if err != nil {
+			return "Failed to read request body"
+		}
+		if len(body) > maxSignedBodyBytes {
+			return "Request body too large to verify"
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	mac := hmac.New(sha256.New, []byte(key.HMACSecret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n", timestamp, nonce, req.Method, req.RequestURI)
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return "Invalid request signature"
+	}
+
+	// Only validly signed nonces are recorded, so other clients cannot use them up
+	if msg := a.nonces.use(key.ID+"\n"+nonce, time.Now()); msg != "" {
+		return msg
+	}
+	return ""
+}
+
+// authorizeCampaign reports whether the authenticated key (if any) may use
+// campaign; handlers call it for campaigns named in the request body
+func authorizeCampaign(c echo.Context, campaign string) bool {
+	key, ok := c.Get(authContextKey).(*APIKey)
+	if !ok {
+		return true
+	}
+	return key.allowsCampaign(campaign)
+}
+
+// forbiddenCampaign is the response for a campaign outside the key's allowlist
+func forbiddenCampaign(c echo.Context, campaign string) error {
+	return c.JSON(http.StatusForbidden, map[string]string{
+		"error": fmt.Sprintf("Not allowed for campaign: %s", campaign),
+	})
+}
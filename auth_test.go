@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// signedRequest builds a request signed as verifySignature expects
+func signedRequest(method, uri, body, secret, nonce string) *http.Request {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", timestamp, nonce, method, uri, body)
+
+	req := httptest.NewRequest(method, uri, strings.NewReader(body))
+	req.Header.Set(headerAPIKey, "dialer-key")
+	req.Header.Set(headerTimestamp, timestamp)
+	req.Header.Set(headerNonce, nonce)
+	req.Header.Set(headerSignature, hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestSignedRequests(t *testing.T) {
+	a := &authenticator{
+		keys:   []APIKey{{ID: "dialer", Key: "dialer-key", Role: roleMatch, HMACSecret: "secret"}},
+		nonces: &nonceCache{seen: make(map[string]time.Time)},
+	}
+	e := echo.New()
+	e.POST("/match", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, a.require(roleMatch, false))
+
+	send := func(req *http.Request) int {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	body := `{"campaign": "fe_basic", "speech_text": "busy"}`
+
+	if code := send(signedRequest(http.MethodPost, "/match", body, "secret", "n1")); code != http.StatusOK {
+		t.Fatalf("signed request: %d, want 200", code)
+	}
+	// The same request again is a replay
+	if code := send(signedRequest(http.MethodPost, "/match", body, "secret", "n1")); code != http.StatusUnauthorized {
+		t.Errorf("replayed nonce: %d, want 401", code)
+	}
+	if code := send(signedRequest(http.MethodPost, "/match", body, "secret", "n2")); code != http.StatusOK {
+		t.Errorf("new nonce: %d, want 200", code)
+	}
+
+	// A request with a wrong signature does not use up its nonce
+	if code := send(signedRequest(http.MethodPost, "/match", body, "wrong", "n3")); code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: %d, want 401", code)
+	}
+	if code := send(signedRequest(http.MethodPost, "/match", body, "secret", "n3")); code != http.StatusOK {
+		t.Errorf("nonce of a rejected request: %d, want 200", code)
+	}
+
+	// The nonce is signed
+	req := signedRequest(http.MethodPost, "/match", body, "secret", "n4")
+	req.Header.Set(headerNonce, "n5")
+	if code := send(req); code != http.StatusUnauthorized {
+		t.Errorf("changed nonce: %d, want 401", code)
+	}
+	req = signedRequest(http.MethodPost, "/match", body, "secret", "n6")
+	req.Header.Del(headerNonce)
+	if code := send(req); code != http.StatusUnauthorized {
+		t.Errorf("missing nonce: %d, want 401", code)
+	}
+}
+
+// Nonces are forgotten once their timestamp could no longer be accepted
+func TestNonceCacheExpiry(t *testing.T) {
+	nc := &nonceCache{seen: make(map[string]time.Time)}
+	now := time.Now()
+	if msg := nc.use("dialer\nn1", now); msg != "" {
+		t.Fatalf("first use: %s", msg)
+	}
+	if msg := nc.use("dialer\nn1", now.Add(maxSignatureSkew)); msg == "" {
+		t.Errorf("nonce reused within the skew window was accepted")
+	}
+	if msg := nc.use("dialer\nn1", now.Add(3*maxSignatureSkew)); msg != "" {
+		t.Errorf("nonce after the skew window: %s", msg)
+	}
+	if len(nc.seen) != 1 {
+		t.Errorf("%d nonces remembered, want the expired one pruned", len(nc.seen))
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+// ahoCorasick is a byte-level multi-pattern automaton.
+// Every keyword of a stage is compiled into one automaton so a single pass over
+// the normalized text reports every occurrence of every keyword.
+// Transitions are stored as a dense DFA over a compressed alphabet: only bytes
+// that appear in at least one pattern get their own symbol, everything else maps
+// to symbol 0 and always falls back to the root.
+type ahoCorasick struct {
+	alphabet [256]uint8 // byte -> symbol (0 = byte not used by any pattern)
+	width    int        // number of symbols including the catch-all symbol 0
+	delta    []int32    // node*width+symbol -> next node
+	outputs  [][]int32  // pattern ids ending exactly at node
+	dict     []int32    // nearest proper suffix node that has outputs (-1 if none)
+	lengths  []int      // pattern id -> pattern length in bytes
+}
+
+// newAhoCorasick compiles patterns into an automaton.
+// Pattern ids are the indexes into the patterns slice; duplicates are allowed and
+// each duplicate is reported separately.
+func newAhoCorasick(patterns []string) *ahoCorasick {
+	ac := &ahoCorasick{
+		lengths: make([]int, len(patterns)),
+	}
+
+	// Build the compressed alphabet
+	symbols := 1
+	for _, p := range patterns {
+		for i := 0; i < len(p); i++ {
+			if ac.alphabet[p[i]] == 0 {
+				ac.alphabet[p[i]] = uint8(symbols)
+				symbols++
+			}
+		}
+	}
+	ac.width = symbols
+
+	// Build the trie (-1 marks a missing edge until failure links are resolved)
+	ac.addNode()
+	for id, p := range patterns {
+		ac.lengths[id] = len(p)
+		if len(p) == 0 {
+			// Empty patterns never match (keywords matched outside the automaton)
+			continue
+		}
+		node := int32(0)
+		for i := 0; i < len(p); i++ {
+			slot := int(node)*ac.width + int(ac.alphabet[p[i]])
+			if ac.delta[slot] < 0 {
+				ac.delta[slot] = ac.addNode()
+			}
+			node = ac.delta[slot]
+		}
+		ac.outputs[node] = append(ac.outputs[node], int32(id))
+	}
+
+	// Resolve failure links breadth-first and turn the trie into a full DFA
+	fail := make([]int32, len(ac.outputs))
+	queue := make([]int32, 0, len(ac.outputs))
+	for s := 0; s < ac.width; s++ {
+		next := ac.delta[s]
+		if next < 0 {
+			ac.delta[s] = 0
+			continue
+		}
+		fail[next] = 0
+		queue = append(queue, next)
+	}
+
+	for head := 0; head < len(queue); head++ {
+		node := queue[head]
+		if len(ac.outputs[fail[node]]) > 0 {
+			ac.dict[node] = fail[node]
+		} else {
+			ac.dict[node] = ac.dict[fail[node]]
+		}
+
+		for s := 0; s < ac.width; s++ {
+			slot := int(node)*ac.width + s
+			next := ac.delta[slot]
+			fallback := ac.delta[int(fail[node])*ac.width+s]
+			if next < 0 {
+				ac.delta[slot] = fallback
+				continue
+			}
+			fail[next] = fallback
+			queue = append(queue, next)
+		}
+	}
+
+	return ac
+}
+
+func (ac *ahoCorasick) addNode() int32 {
+	id := int32(len(ac.outputs))
+	for s := 0; s < ac.width; s++ {
+		ac.delta = append(ac.delta, -1)
+	}
+	ac.outputs = append(ac.outputs, nil)
+	ac.dict = append(ac.dict, -1)
+	return id
+}
+
+// scan reports every pattern occurrence in text as (pattern id, start, end) byte offsets
+func (ac *ahoCorasick) scan(text string, emit func(pattern, start, end int)) {
+	node := int32(0)
+	for i := 0; i < len(text); i++ {
+		node = ac.delta[int(node)*ac.width+int(ac.alphabet[text[i]])]
+		for out := node; out >= 0; out = ac.dict[out] {
+			for _, id := range ac.outputs[out] {
+				emit(int(id), i+1-ac.lengths[id], i+1)
+			}
+		}
+	}
+}
+
+// isWordByte reports whether b is a regexp word character ([0-9A-Za-z_]).
+// Bytes of multi-byte UTF-8 sequences are never word characters, which matches
+// the ASCII-only semantics of \b in Go's regexp package.
+func isWordByte(b byte) bool {
+	return b == '_' ||
+		('0' <= b && b <= '9') ||
+		('a' <= b && b <= 'z') ||
+		('A' <= b && b <= 'Z')
+}
+
+// isWordBoundary reports whether \b would match at byte offset pos of text
+func isWordBoundary(text string, pos int) bool {
+	before := pos > 0 && isWordByte(text[pos-1])
+	after := pos < len(text) && isWordByte(text[pos])
+	return before != after
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// batchWorkers is the number of concurrent ProcessStage workers per batch request
+var batchWorkers = 4
+
+// batchItem is one request of a batch together with its position in the input
+type batchItem struct {
+	index int
+	req   MatchRequest
+	err   string // Set when the item could not be decoded
+}
+
+// batchMatcherSet resolves each campaign of a batch through getMatcher only once
+type batchMatcherSet struct {
+	sync.Mutex
+	matchers map[string]*KeywordMatcher
+	errors   map[string]error
+}
+
+func newBatchMatcherSet() *batchMatcherSet {
+	return &batchMatcherSet{
+		matchers: make(map[string]*KeywordMatcher),
+		errors:   make(map[string]error),
+	}
+}
+
+func (bs *batchMatcherSet) get(campaign string) (*KeywordMatcher, error) {
+	bs.Lock()
+	defer bs.Unlock()
+
+	if matcher, ok := bs.matchers[campaign]; ok {
+		return matcher, nil
+	}
+	if err, ok := bs.errors[campaign]; ok {
+		return nil, err
+	}
+
+	matcher, err := getMatcher(campaign)
+	if err != nil {
+		bs.errors[campaign] = err
+		return nil, err
+	}
+	bs.matchers[campaign] = matcher
+	return matcher, nil
+}
+
+// processBatch pulls items from next until it reports no more items, matches
+// them on a bounded worker pool and calls emit with the results in input order.
+// The number of items in flight is bounded so a streamed batch never buffers
+// more than a few results per worker, regardless of its total size.
+// Stops early and returns the error if next or emit fail.
+func processBatch(next func() (batchItem, bool, error), emit func(BatchMatchResult) error, workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	matchers := newBatchMatcherSet()
+	jobs := make(chan batchItem)
+	results := make(chan BatchMatchResult)
+	inFlight := make(chan struct{}, workers*4)
+	done := make(chan struct{})
+
+	// Workers
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				results <- matchBatchItem(item, matchers)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Collector: reorder results and emit them in input order
+	var emitErr error
+	var emitFailed atomic.Bool
+	go func() {
+		defer close(done)
+		pending := make(map[int]BatchMatchResult)
+		nextIndex := 0
+		for result := range results {
+			pending[result.Index] = result
+			for {
+				ready, ok := pending[nextIndex]
+				if !ok {
+					break
+				}
+				delete(pending, nextIndex)
+				nextIndex++
+				if emitErr == nil {
+					if emitErr = emit(ready); emitErr != nil {
+						emitFailed.Store(true)
+					}
+				}
+				<-inFlight
+			}
+		}
+	}()
+
+	// Reader: feed items to the workers
+	var readErr error
+	for index := 0; !emitFailed.Load(); index++ {
+		item, ok, err := next()
+		if err != nil {
+			readErr = err
+			break
+		}
+		if !ok {
+			break
+		}
+		item.index = index
+		inFlight <- struct{}{}
+		jobs <- item
+	}
+	close(jobs)
+	<-done
+
+	if readErr != nil {
+		return readErr
+	}
+	return emitErr
+}
+
+// matchBatchItem validates and matches a single batch item
+func matchBatchItem(item batchItem, matchers *batchMatcherSet) BatchMatchResult {
+	result := BatchMatchResult{
+		Index:    item.index,
+		Stage:    item.req.Stage,
+		Campaign: item.req.Campaign,
+	}
+
+	if item.err != "" {
+		result.Error = item.err
+		return result
+	}
+	if msg := validateMatchRequest(&item.req); msg != "" {
+		result.Error = msg
+		return result
+	}
+
+	matcher, err := matchers.get(item.req.Campaign)
+	if err != nil {
+		result.Error = fmt.Sprintf("Campaign not found: %s", item.req.Campaign)
+		return result
+	}
+
+	if len(item.req.Alternatives) > 0 {
+		decision := matchAlternativesObserved(matcher, &item.req)
+		result.Result = decision.returnValue()
+		result.Score = scoreOf(decision.result)
+		result.Alternative = decision.alternativeIndex()
+		return result
+	}
+
+	match := processStageObserved(matcher, item.req.Campaign, item.req.SpeechText, item.req.Stage)
+	result.Result = returnValueOf(match)
+	result.Score = scoreOf(match)
+	return result
+}
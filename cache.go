@@ -1,11 +1,14 @@
 package main
 
 import (
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,6 +21,10 @@ type CampaignCache struct {
 	sync.RWMutex
 	matchers     map[string]*KeywordMatcher
 	fileModTimes map[string]time.Time
+	reloadErrors map[string]*ReloadError // campaign -> last failed reload
+	reloading    map[string]bool         // Campaigns with a background reload running
+	reloadMu     sync.Mutex              // Serializes reloads so versions are swapped in order
+	editMu       sync.Mutex              // Serializes campaign file edits (see editCampaign)
 	watcher      *fsnotify.Watcher
 	keywordsDir  string
 }
@@ -30,8 +37,13 @@ func NewCampaignCache(keywordsDir string) (*CampaignCache, error) {
 		return nil, fmt.Errorf("failed to create file watcher: %w", err)
 	}
 
-	// Add keywords directory to watcher
-	err = watcher.Add(keywordsDir)
+	// Add keywords directory to watcher, with its subdirectories (shared libraries)
+	err = filepath.WalkDir(keywordsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		return watcher.Add(path)
+	})
 	if err != nil {
 		watcher.Close()
 		return nil, fmt.Errorf("failed to watch keywords directory: %w", err)
@@ -40,6 +52,8 @@ func NewCampaignCache(keywordsDir string) (*CampaignCache, error) {
 	cache := &CampaignCache{
 		matchers:     make(map[string]*KeywordMatcher),
 		fileModTimes: make(map[string]time.Time),
+		reloadErrors: make(map[string]*ReloadError),
+		reloading:    make(map[string]bool),
 		watcher:      watcher,
 		keywordsDir:  keywordsDir,
 	}
@@ -64,27 +78,19 @@ func (cc *CampaignCache) WatchFiles() {
 				return
 			}
 
-			// Only process Write and Create events for .json files
-			if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
-				if strings.HasSuffix(event.Name, ".json") {
-					// Extract campaign name from filename
-					campaign := strings.TrimSuffix(filepath.Base(event.Name), ".json")
-
-					// Small delay to ensure file write is complete
-					time.Sleep(100 * time.Millisecond)
-
-					log.Printf("File changed: %s, reloading campaign: %s", event.Name, campaign)
-
-					// Reload the campaign
-					cc.Lock()
-					delete(cc.matchers, campaign)
-					delete(cc.fileModTimes, campaign)
-					cc.Unlock()
-
-					log.Printf("Campaign '%s' cache cleared, will reload on next request", campaign)
+			// Directories created after startup (e.g. a new library folder) are watched too
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					cc.watchDirectory(event.Name)
+					continue
 				}
 			}
 
+			// Only process Write and Create events for .json files
+			if event.Op&(fsnotify.Write|fsnotify.Create) != 0 && strings.HasSuffix(event.Name, ".json") {
+				cc.fileChanged(event.Name)
+			}
+
 		case err, ok := <-cc.watcher.Errors:
 			if !ok {
 				return
@@ -94,26 +100,162 @@ func (cc *CampaignCache) WatchFiles() {
 	}
 }
 
+// fileChanged reloads every cached campaign affected by a change of the file at path
+// Campaigns that were never requested are loaded lazily by getMatcher.
+func (cc *CampaignCache) fileChanged(path string) {
+	campaigns := cc.affectedCampaigns(path)
+	if len(campaigns) == 0 {
+		return
+	}
+
+	// Small delay to ensure file write is complete
+	time.Sleep(100 * time.Millisecond)
+
+	for _, campaign := range campaigns {
+		log.Printf("File changed: %s, reloading campaign: %s", path, campaign)
+
+		// Build the new version in the background; the old one keeps serving
+		// until it is swapped in, and stays if the new file is invalid
+		go cc.reloadCampaign(campaign, false)
+	}
+}
+
+// watchDirectory adds a directory created after startup, with its
+// subdirectories, to the watcher; JSON files already in it (e.g. a library
+// folder moved into place) count as changed
+func (cc *CampaignCache) watchDirectory(dir string) {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return cc.watcher.Add(path)
+		}
+		if strings.HasSuffix(path, ".json") {
+			cc.fileChanged(path)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: failed to watch %s: %v", dir, err)
+		return
+	}
+	log.Printf("File watcher added: %s", dir)
+}
+
+// affectedCampaigns returns the cached campaigns that must be reloaded when the
+// file at path changes: the campaign stored in it, and every campaign that
+// extends or includes it
+func (cc *CampaignCache) affectedCampaigns(path string) []string {
+	path = filepath.Clean(path)
+
+	cc.RLock()
+	defer cc.RUnlock()
+
+	campaigns := make([]string, 0)
+	for campaign, matcher := range cc.matchers {
+		if filepath.Clean(matcher.filePath) == path || matcher.dependsOn(path) {
+			campaigns = append(campaigns, campaign)
+		}
+	}
+	sort.Strings(campaigns)
+	return campaigns
+}
+
+// isFileModified reports whether filePath changed since the cached version was loaded
 func (cc *CampaignCache) isFileModified(filePath string) (bool, error) {
 	info, err := os.Stat(filePath)
 	if err != nil {
 		return false, err
 	}
 
-	modTime := info.ModTime()
-
 	cc.RLock()
 	lastModTime, exists := cc.fileModTimes[filePath]
 	cc.RUnlock()
 
-	if !exists || modTime.After(lastModTime) {
-		cc.Lock()
-		cc.fileModTimes[filePath] = modTime
-		cc.Unlock()
-		return true, nil
+	return !exists || info.ModTime().After(lastModTime), nil
+}
+
+// reloadCampaign builds a new KeywordMatcher from the campaign file, validates it
+// and atomically swaps it into the cache. On failure the previous version (if any)
+// keeps serving and the error is recorded for /admin/cache-info.
+// Unless force is set, the file is not reloaded if it has not changed since the
+// cached version was loaded (several callers may notice the same change).
+func (cc *CampaignCache) reloadCampaign(campaign string, force bool) (*KeywordMatcher, error) {
+	cc.reloadMu.Lock()
+	defer cc.reloadMu.Unlock()
+
+	filePath := filepath.Join(cc.keywordsDir, campaign+".json")
+	var modTime time.Time
+	info, statErr := os.Stat(filePath)
+	if statErr == nil {
+		modTime = info.ModTime()
+	}
+
+	cc.RLock()
+	current, exists := cc.matchers[campaign]
+	lastModTime := cc.fileModTimes[filePath]
+	cc.RUnlock()
+	if exists && !force && !modTime.After(lastModTime) && !current.dependenciesModified() {
+		return current, nil
+	}
+
+	// Unknown campaigns are not recorded as failed reloads
+	if statErr != nil && !exists {
+		return nil, fmt.Errorf("failed to load campaign keywords: %w", statErr)
+	}
+
+	// Parse and validate outside the cache lock; requests keep being served
+	matcher, err := loadKeywordMatcher(filePath)
+	if err == nil {
+		err = validateKeywordMatcher(matcher)
+	}
+
+	cc.Lock()
+	defer cc.Unlock()
+
+	// Remember the version we tried so a broken file is not re-parsed on every request
+	cc.fileModTimes[filePath] = modTime
+
+	if err != nil {
+		reloadsTotal.inc(campaign, "failure")
+		cc.reloadErrors[campaign] = &ReloadError{
+			Error:       err.Error(),
+			FailedAt:    time.Now(),
+			KeptVersion: exists,
+		}
+		if exists {
+			log.Printf("Reload of campaign '%s' failed, keeping previous version loaded at %s: %v",
+				campaign, current.loadedAt.Format(time.RFC3339), err)
+		} else {
+			log.Printf("Failed to load campaign '%s': %v", campaign, err)
+		}
+		return nil, err
 	}
 
-	return false, nil
+	// Keyword hit counters survive reloads and edits
+	if exists {
+		matcher.carryKeywordStats(current)
+	}
+	cc.matchers[campaign] = matcher
+	delete(cc.reloadErrors, campaign)
+	reloadsTotal.inc(campaign, "success")
+	log.Printf("Loaded campaign: %s", campaign)
+
+	return matcher, nil
+}
+
+// validateKeywordMatcher rejects matchers that would silently answer "unknown"
+// for everything, e.g. a file saved with all categories removed or renamed
+func validateKeywordMatcher(km *KeywordMatcher) error {
+	keywords := 0
+	for _, stageData := range km.stageMap {
+		keywords += len(stageData.patterns)
+	}
+	if keywords == 0 {
+		return fmt.Errorf("campaign file has no valid categories")
+	}
+	return nil
 }
 
 func getMatcher(campaign string) (*KeywordMatcher, error) {
@@ -121,46 +263,63 @@ func getMatcher(campaign string) (*KeywordMatcher, error) {
 
 	// Check if file has been modified
 	modified, err := campaignCache.isFileModified(filePath)
-	if err == nil && modified {
-		// File was modified, clear cache
-		campaignCache.Lock()
-		delete(campaignCache.matchers, campaign)
-		campaignCache.Unlock()
-		log.Printf("Detected modification for %s, reloading...", campaign)
-	}
 
 	campaignCache.RLock()
 	matcher, exists := campaignCache.matchers[campaign]
 	campaignCache.RUnlock()
 
+	if exists && (err != nil || !modified) && !matcher.dependenciesModified() {
+		cacheRequestsTotal.inc("hit")
+		return matcher, nil
+	}
+	cacheRequestsTotal.inc("miss")
+
+	// A modified file is reloaded in the background; requests keep getting the
+	// current version until the new one is swapped in
 	if exists {
+		campaignCache.reloadInBackground(campaign)
 		return matcher, nil
 	}
 
-	// Load campaign keywords
-	campaignCache.Lock()
-	defer campaignCache.Unlock()
+	// Campaigns are loaded on their first request
+	return campaignCache.reloadCampaign(campaign, false)
+}
 
-	// Double-check after acquiring write lock
-	if matcher, exists := campaignCache.matchers[campaign]; exists {
-		return matcher, nil
+// reloadInBackground reloads campaign unless a reload started here is still running
+func (cc *CampaignCache) reloadInBackground(campaign string) {
+	cc.Lock()
+	if cc.reloading[campaign] {
+		cc.Unlock()
+		return
 	}
+	cc.reloading[campaign] = true
+	cc.Unlock()
+
+	log.Printf("Detected modification for %s, reloading...", campaign)
+	go func() {
+		defer func() {
+			cc.Lock()
+			delete(cc.reloading, campaign)
+			cc.Unlock()
+		}()
+		// Failures are logged and recorded by reloadCampaign
+		cc.reloadCampaign(campaign, false)
+	}()
+}
 
-	// Load from file
+// loadKeywordMatcher reads and parses a campaign file into a new KeywordMatcher
+// without touching the cache (used by getMatcher and the offline subcommands)
+func loadKeywordMatcher(filePath string) (*KeywordMatcher, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load campaign keywords: %w", err)
 	}
 
-	var rawKeywords FlexibleKeywordSets
-	if err := json.Unmarshal(data, &rawKeywords); err != nil {
-		return nil, fmt.Errorf("failed to parse campaign keywords: %w", err)
-	}
-
-	matcher = NewKeywordMatcher(rawKeywords, filePath)
-	campaignCache.matchers[campaign] = matcher
-
-	log.Printf("Loaded campaign: %s", campaign)
+	return newKeywordMatcherFromData(filePath, data)
+}
 
-	return matcher, nil
+// campaignVersion identifies the content of a campaign file
+func campaignVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A modified campaign keeps being served from the cache while it reloads
+func TestGetMatcherReloadsInBackground(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "campaign.json")
+	writeTestFile(t, path, `{"busy_p1_s1": ["busy"]}`)
+	useTestCampaignCache(t, dir)
+
+	first, err := getMatcher("campaign")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, path, `{"busy_p1_s1": ["busy", "driving"]}`)
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold the reload lock: a request must not wait for the reload
+	campaignCache.reloadMu.Lock()
+	served := make(chan *KeywordMatcher)
+	go func() {
+		km, _ := getMatcher("campaign")
+		served <- km
+	}()
+	select {
+	case km := <-served:
+		if km != first {
+			t.Errorf("request during the reload got a new version, want the current one")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request waited for the reload")
+	}
+	// Further requests join the running reload
+	getMatcher("campaign")
+	campaignCache.RLock()
+	running := campaignCache.reloading["campaign"]
+	campaignCache.RUnlock()
+	if !running {
+		t.Errorf("no reload running for the modified campaign")
+	}
+	campaignCache.reloadMu.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		km, err := getMatcher("campaign")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if km != first {
+			if got := returnValueOf(km.peekStage("driving", "s1")); got != "busy" {
+				t.Errorf("reloaded version matched driving as %s, want busy", got)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the modified campaign was never reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,922 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Admin API for editing campaign files.
+// Every write reads the file, checks the If-Match header against the ETag of its
+// content, applies the change to an order-preserving copy of the document,
+// validates the result by building a KeywordMatcher from it, replaces the file
+// atomically (temp file + rename) and reloads the campaign through the cache.
+
+// CampaignCategory is one category as listed by the admin API
+type CampaignCategory struct {
+	Key         string   `json:"key"`
+	Stage       string   `json:"stage"`
+	Priority    int      `json:"priority"`
+	Hardcoded   bool     `json:"hardcoded"`
+	ReturnValue string   `json:"return_value"`
+	Description string   `json:"description,omitempty"`
+	Keywords    []string `json:"keywords"`
+}
+
+type CampaignCategoriesResponse struct {
+	Campaign      string             `json:"campaign"`
+	ETag          string             `json:"etag"`
+	FormatVersion int                `json:"format_version"`
+	Categories    []CampaignCategory `json:"categories"`
+}
+
+// CategoryEditRequest is the body of category create and update requests
+// Updates only change the fields that are set.
+type CategoryEditRequest struct {
+	Name        string   `json:"name"` // Category name, e.g. "notInterested" (create only)
+	Stage       *string  `json:"stage"`
+	Priority    *int     `json:"priority"`
+	Hardcoded   *bool    `json:"hardcoded"`
+	ReturnValue string   `json:"return_value"` // Version 2 files only (create only)
+	Description string   `json:"description"`  // Version 2 files only (create only)
+	Keywords    []string `json:"keywords"`
+}
+
+type KeywordsEditRequest struct {
+	Keywords []string `json:"keywords"`
+}
+
+var (
+	categoryNameRegex = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+	stageRegex        = regexp.MustCompile(`^s[0-9]+$`)
+)
+
+// editError is an edit failure with the HTTP status to report
+type editError struct {
+	status  int
+	message string
+}
+
+func (e *editError) Error() string { return e.message }
+
+func newEditError(status int, format string, args ...interface{}) error {
+	return &editError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// campaignETag is the ETag of a campaign file's content
+func campaignETag(data []byte) string {
+	return `"` + campaignVersion(data) + `"`
+}
+
+// jsonObject is a JSON object that keeps its members in file order
+// Member values are kept as raw JSON, so nested objects keep their order too.
+type jsonObject struct {
+	keys   []string
+	values map[string]json.RawMessage
+}
+
+func parseJSONObject(data []byte) (*jsonObject, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errors.New("not a JSON object")
+	}
+
+	obj := &jsonObject{values: make(map[string]json.RawMessage)}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		if _, exists := obj.values[key]; !exists {
+			obj.keys = append(obj.keys, key)
+		}
+		obj.values[key] = value
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (o *jsonObject) get(key string) (json.RawMessage, bool) {
+	value, ok := o.values[key]
+	return value, ok
+}
+
+// child returns the member key parsed as an object, or nil
+func (o *jsonObject) child(key string) *jsonObject {
+	value, ok := o.values[key]
+	if !ok {
+		return nil
+	}
+	child, err := parseJSONObject(value)
+	if err != nil {
+		return nil
+	}
+	return child
+}
+
+// set replaces the value of key, or appends key if it is new
+func (o *jsonObject) set(key string, value interface{}) error {
+	data, err := marshalJSON(value)
+	if err != nil {
+		return err
+	}
+	if _, exists := o.values[key]; !exists {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = data
+	return nil
+}
+
+func (o *jsonObject) remove(key string) {
+	if _, exists := o.values[key]; !exists {
+		return
+	}
+	delete(o.values, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// rename changes a key in place, keeping its position
+func (o *jsonObject) rename(oldKey, newKey string) {
+	value, exists := o.values[oldKey]
+	if !exists {
+		return
+	}
+	delete(o.values, oldKey)
+	o.values[newKey] = value
+	for i, k := range o.keys {
+		if k == oldKey {
+			o.keys[i] = newKey
+			break
+		}
+	}
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := marshalJSON(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(o.values[key])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// marshalJSON encodes v without escaping HTML characters, as campaign files are
+// written by hand
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// campaignDocument is a campaign file being edited
+type campaignDocument struct {
+	top      *jsonObject
+	version  int
+	filePath string
+	resolved *resolvedCampaign // The file with its base campaign and libraries merged in
+	matcher  *KeywordMatcher   // Campaign settings only, to normalize keywords
+}
+
+// newCampaignDocument parses a campaign file for editing
+// A file without "version" is edited in the format of its base campaign.
+func newCampaignDocument(filePath string, data []byte) (*campaignDocument, error) {
+	top, err := parseJSONObject(data)
+	if err != nil {
+		return nil, newEditError(http.StatusUnprocessableEntity, "Campaign file is not a JSON object: %v", err)
+	}
+	resolved, err := resolveCampaign(filePath, data)
+	if err != nil {
+		return nil, newEditError(http.StatusUnprocessableEntity, "Campaign file is invalid: %v", err)
+	}
+	version, ok := campaignFormatVersion(resolved.raw)
+	if !ok {
+		return nil, newEditError(http.StatusUnprocessableEntity, "Unsupported campaign file version %v", resolved.raw[versionKey])
+	}
+
+	settings := make(FlexibleKeywordSets)
+	if value, exists := resolved.raw[settingsKey]; exists {
+		settings[settingsKey] = value
+	}
+	return &campaignDocument{
+		top:      top,
+		version:  version,
+		filePath: filePath,
+		resolved: resolved,
+		matcher:  NewKeywordMatcher(settings, nil, filePath),
+	}, nil
+}
+
+// categories returns the object holding the categories: the top level for flat
+// files, the "categories" object for version 2 files
+func (d *campaignDocument) categories() (*jsonObject, error) {
+	if d.version != 2 {
+		return d.top, nil
+	}
+	categories := d.top.child(categoriesKey)
+	if categories == nil {
+		return nil, newEditError(http.StatusUnprocessableEntity, "Version 2 campaign file has no \"categories\" object")
+	}
+	return categories, nil
+}
+
+// setCategories stores an edited categories object back into the document
+func (d *campaignDocument) setCategories(categories *jsonObject) error {
+	if d.version != 2 {
+		return nil
+	}
+	return d.top.set(categoriesKey, categories)
+}
+
+// isCategoryKey reports whether key names a category (and not a reserved key)
+func (d *campaignDocument) isCategoryKey(categories *jsonObject, key string) bool {
+	if _, exists := categories.get(key); !exists {
+		return false
+	}
+	return d.version == 2 || (key != settingsKey && key != versionKey && !isInheritanceKey(key))
+}
+
+// categoryNotFound is the error for a category the file does not define. A
+// category that the campaign inherits is reported with the file defining it.
+func (d *campaignDocument) categoryNotFound(status int, key string) error {
+	if !definesCategory(d.resolved.raw, key) {
+		return newEditError(http.StatusNotFound, "Category not found: %s", key)
+	}
+	return newEditError(status, "Category %s is inherited from %s; edit that file, or use %q and %q in this one",
+		key, d.definingFile(key), appendKey, removeKey)
+}
+
+// definingFile names the base campaign or library that defines key, relative to
+// the campaign file; the last file in merge order wins, as in resolveCampaign
+func (d *campaignDocument) definingFile(key string) string {
+	name := "its base campaign"
+	for _, path := range d.resolved.files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		raw, _, err := parseCampaignData(data)
+		if err != nil || !definesCategory(raw, key) {
+			continue
+		}
+		name = path
+		if rel, err := filepath.Rel(filepath.Dir(d.filePath), path); err == nil {
+			name = rel
+		}
+	}
+	return name
+}
+
+// definesCategory reports whether a campaign, in either format, has the category key
+func definesCategory(raw FlexibleKeywordSets, key string) bool {
+	if categories, ok := raw[categoriesKey].(map[string]interface{}); ok {
+		if _, exists := categories[key]; exists {
+			return true
+		}
+	}
+	if key == settingsKey || key == versionKey || key == categoriesKey || isInheritanceKey(key) {
+		return false
+	}
+	_, exists := raw[key]
+	return exists
+}
+
+// keywordKey is what two keywords of a category must share to be duplicates:
+// their text after the campaign's normalization, so "I'm busy" and "i am busy"
+// are the same keyword
+func (d *campaignDocument) keywordKey(keyword string) string {
+	return d.matcher.normalizeText(keyword)
+}
+
+// keywordsOf returns the keyword list of a category and a function that stores
+// an edited list back
+func (d *campaignDocument) keywordsOf(categories *jsonObject, key string) ([]json.RawMessage, func([]json.RawMessage) error, error) {
+	if !d.isCategoryKey(categories, key) {
+		return nil, nil, d.categoryNotFound(http.StatusConflict, key)
+	}
+
+	container, member := categories, key
+	if d.version == 2 {
+		container = categories.child(key)
+		if container == nil {
+			return nil, nil, newEditError(http.StatusUnprocessableEntity, "Category %s is not an object", key)
+		}
+		member = "keywords"
+	}
+
+	var keywords []json.RawMessage
+	if value, ok := container.get(member); ok && string(value) != "null" {
+		if err := json.Unmarshal(value, &keywords); err != nil {
+			return nil, nil, newEditError(http.StatusUnprocessableEntity, "Keywords of category %s are not a list", key)
+		}
+	}
+
+	store := func(keywords []json.RawMessage) error {
+		if keywords == nil {
+			keywords = make([]json.RawMessage, 0)
+		}
+		if err := container.set(member, keywords); err != nil {
+			return err
+		}
+		if d.version == 2 {
+			if err := categories.set(key, container); err != nil {
+				return err
+			}
+		}
+		return d.setCategories(categories)
+	}
+	return keywords, store, nil
+}
+
+// renameSettingsReferences updates settings that refer to a category by key
+// (match_modes and negation rules); an empty newKey removes them
+func (d *campaignDocument) renameSettingsReferences(oldKey, newKey string) error {
+	settings := d.top.child(settingsKey)
+	if settings == nil {
+		return nil
+	}
+
+	if modes := settings.child("match_modes"); modes != nil {
+		if newKey == "" {
+			modes.remove(oldKey)
+		} else {
+			modes.rename(oldKey, newKey)
+		}
+		if err := settings.set("match_modes", modes); err != nil {
+			return err
+		}
+	}
+
+	if negation := settings.child("negation"); negation != nil {
+		if rules := negation.child("categories"); rules != nil {
+			if newKey == "" {
+				rules.remove(oldKey)
+			} else {
+				rules.rename(oldKey, newKey)
+				for _, key := range rules.keys {
+					rule := rules.child(key)
+					if rule == nil {
+						continue
+					}
+					var opposite string
+					if value, ok := rule.get("opposite"); ok && json.Unmarshal(value, &opposite) == nil && opposite == oldKey {
+						if err := rule.set("opposite", newKey); err != nil {
+							return err
+						}
+						if err := rules.set(key, rule); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			if err := negation.set("categories", rules); err != nil {
+				return err
+			}
+		}
+		if err := settings.set("negation", negation); err != nil {
+			return err
+		}
+	}
+
+	return d.top.set(settingsKey, settings)
+}
+
+// format encodes the document with the indentation of the original file
+func (d *campaignDocument) format(original []byte) ([]byte, error) {
+	compact, err := d.top.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	indent := "  "
+	if i := bytes.IndexByte(original, '\n'); i >= 0 {
+		rest := original[i+1:]
+		if n := len(rest) - len(bytes.TrimLeft(rest, " \t")); n > 0 {
+			indent = string(rest[:n])
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, compact, "", indent); err != nil {
+		return nil, err
+	}
+	if bytes.HasSuffix(original, []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// flatCategoryKey builds a flat format key: {category}_{priority}_{stage}
+func flatCategoryKey(name string, priority int, hardcoded bool, stage string) string {
+	if hardcoded {
+		return fmt.Sprintf("%s_hardcoded_%s", name, stage)
+	}
+	return fmt.Sprintf("%s_p%d_%s", name, priority, stage)
+}
+
+// listCampaignCategories returns the categories of a campaign file as written
+// Inherited categories are not listed; a file without "version" is read in the
+// format of its base campaign.
+func listCampaignCategories(filePath string, data []byte) ([]CampaignCategory, int, error) {
+	rawKeywords, order, err := parseCampaignData(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, explicit := rawKeywords[versionKey]; !explicit {
+		if resolved, err := resolveCampaign(filePath, data); err == nil {
+			if value, ok := resolved.raw[versionKey]; ok {
+				rawKeywords[versionKey] = value
+			}
+		}
+	}
+
+	km := &KeywordMatcher{settings: defaultCampaignSettings()}
+	if value, ok := rawKeywords[settingsKey]; ok {
+		km.settings, _ = parseCampaignSettings(value)
+	}
+	version, _ := campaignFormatVersion(rawKeywords)
+
+	categories := make([]CampaignCategory, 0)
+	for _, def := range km.categoryDefinitions(rawKeywords, order) {
+		keywords := def.Keywords
+		if keywords == nil {
+			keywords = make([]string, 0)
+		}
+		categories = append(categories, CampaignCategory{
+			Key:         def.Info.Key,
+			Stage:       def.Info.Stage,
+			Priority:    def.Info.Priority,
+			Hardcoded:   def.Info.IsHardcoded,
+			ReturnValue: def.Info.ReturnValue,
+			Description: def.Info.Description,
+			Keywords:    keywords,
+		})
+	}
+	return categories, version, nil
+}
+
+// campaignFilePath is the path of a campaign file in the keywords directory
+func campaignFilePath(campaign string) string {
+	return filepath.Join(campaignCache.keywordsDir, campaign+".json")
+}
+
+// readCampaignFile reads the file of a campaign for the admin API
+func readCampaignFile(campaign string) (string, []byte, error) {
+	if campaign == "" || strings.ContainsAny(campaign, `/\`) || strings.HasPrefix(campaign, ".") {
+		return "", nil, newEditError(http.StatusBadRequest, "Invalid campaign name: %s", campaign)
+	}
+	filePath := campaignFilePath(campaign)
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil, newEditError(http.StatusNotFound, "Campaign not found: %s", campaign)
+		}
+		return "", nil, err
+	}
+	return filePath, data, nil
+}
+
+// writeFileAtomic replaces path with data so readers see either the old or the
+// new content, never a partial file
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	// Hidden name without the .json suffix, so the watcher ignores it
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// editCampaign runs one edit of a campaign file under optimistic concurrency
+// and returns the new file content
+func editCampaign(campaign, ifMatch string, apply func(doc *campaignDocument) error) ([]byte, error) {
+	campaignCache.editMu.Lock()
+	defer campaignCache.editMu.Unlock()
+
+	filePath, data, err := readCampaignFile(campaign)
+	if err != nil {
+		return nil, err
+	}
+
+	// "*" would skip the check, so only the ETag itself is accepted
+	etag := campaignETag(data)
+	if ifMatch == "" || ifMatch == "*" {
+		return nil, newEditError(http.StatusPreconditionRequired, "If-Match header with the campaign ETag is required")
+	}
+	if strings.TrimPrefix(ifMatch, "W/") != etag {
+		return nil, newEditError(http.StatusPreconditionFailed, "Campaign was modified (current ETag %s)", etag)
+	}
+
+	doc, err := newCampaignDocument(filePath, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := apply(doc); err != nil {
+		return nil, err
+	}
+
+	updated, err := doc.format(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never write a file the reload would reject
+	matcher, err := newKeywordMatcherFromData(filePath, updated)
+	if err == nil {
+		err = validateKeywordMatcher(matcher)
+	}
+	if err != nil {
+		return nil, newEditError(http.StatusUnprocessableEntity, "Edit would produce an invalid campaign: %v", err)
+	}
+
+	if err := writeFileAtomic(filePath, updated); err != nil {
+		return nil, fmt.Errorf("failed to write campaign file: %w", err)
+	}
+	if _, err := campaignCache.reloadCampaign(campaign, true); err != nil {
+		return nil, fmt.Errorf("campaign file written but reload failed: %w", err)
+	}
+
+	return updated, nil
+}
+
+// editResponse reports the result of editCampaign: the category with key (or the
+// whole campaign when key is empty) and the new ETag
+func editResponse(c echo.Context, status int, campaign, key string, data []byte, err error) error {
+	if err != nil {
+		return editErrorResponse(c, err)
+	}
+
+	c.Response().Header().Set("ETag", campaignETag(data))
+	categories, version, err := listCampaignCategories(campaignFilePath(campaign), data)
+	if err != nil {
+		return editErrorResponse(c, err)
+	}
+	if key != "" {
+		for _, category := range categories {
+			if category.Key == key {
+				return c.JSON(status, category)
+			}
+		}
+	}
+	return c.JSON(status, CampaignCategoriesResponse{
+		Campaign:      campaign,
+		ETag:          campaignETag(data),
+		FormatVersion: version,
+		Categories:    categories,
+	})
+}
+
+func editErrorResponse(c echo.Context, err error) error {
+	var ee *editError
+	if errors.As(err, &ee) {
+		return c.JSON(ee.status, map[string]string{"error": ee.message})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+func handleListCategories(c echo.Context) error {
+	campaign := c.Param("campaign")
+	_, data, err := readCampaignFile(campaign)
+	if err != nil {
+		return editErrorResponse(c, err)
+	}
+	return editResponse(c, http.StatusOK, campaign, "", data, nil)
+}
+
+func handleGetCategory(c echo.Context) error {
+	campaign, key := c.Param("campaign"), c.Param("category")
+	filePath, data, err := readCampaignFile(campaign)
+	if err != nil {
+		return editErrorResponse(c, err)
+	}
+
+	categories, _, err := listCampaignCategories(filePath, data)
+	if err != nil {
+		return editErrorResponse(c, err)
+	}
+	for _, category := range categories {
+		if category.Key == key {
+			c.Response().Header().Set("ETag", campaignETag(data))
+			return c.JSON(http.StatusOK, category)
+		}
+	}
+	if doc, err := newCampaignDocument(filePath, data); err == nil {
+		return editErrorResponse(c, doc.categoryNotFound(http.StatusNotFound, key))
+	}
+	return c.JSON(http.StatusNotFound, map[string]string{"error": fmt.Sprintf("Category not found: %s", key)})
+}
+
+func handleCreateCategory(c echo.Context) error {
+	campaign := c.Param("campaign")
+
+	var req CategoryEditRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if !categoryNameRegex.MatchString(req.Name) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "name is required and may only contain letters and digits"})
+	}
+	if req.Stage == nil || !stageRegex.MatchString(*req.Stage) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid stage format. Must be s1, s2, s3, etc."})
+	}
+	hardcoded := req.Hardcoded != nil && *req.Hardcoded
+	priority := 0
+	if req.Priority != nil {
+		priority = *req.Priority
+	}
+	if !hardcoded && priority < 1 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "priority must be 1 or higher unless hardcoded"})
+	}
+
+	var key string
+	data, err := editCampaign(campaign, c.Request().Header.Get("If-Match"), func(doc *campaignDocument) error {
+		categories, err := doc.categories()
+		if err != nil {
+			return err
+		}
+
+		keywords := req.Keywords
+		if keywords == nil {
+			keywords = make([]string, 0)
+		}
+
+		if doc.version != 2 {
+			if req.ReturnValue != "" || req.Description != "" {
+				return newEditError(http.StatusBadRequest, "return_value and description require a version 2 campaign file")
+			}
+			key = flatCategoryKey(req.Name, priority, hardcoded, *req.Stage)
+			if _, exists := categories.get(key); exists {
+				return newEditError(http.StatusConflict, "Category already exists: %s", key)
+			}
+			return categories.set(key, keywords)
+		}
+
+		key = req.Name
+		if _, exists := categories.get(key); exists {
+			return newEditError(http.StatusConflict, "Category already exists: %s", key)
+		}
+		category := &jsonObject{values: make(map[string]json.RawMessage)}
+		category.set("stage", *req.Stage)
+		if hardcoded {
+			category.set("hardcoded", true)
+		} else {
+			category.set("priority", priority)
+		}
+		if req.ReturnValue != "" {
+			category.set("return_value", req.ReturnValue)
+		}
+		category.set("keywords", keywords)
+		if req.Description != "" {
+			category.set("description", req.Description)
+		}
+		if err := categories.set(key, category); err != nil {
+			return err
+		}
+		return doc.setCategories(categories)
+	})
+
+	return editResponse(c, http.StatusCreated, campaign, key, data, err)
+}
+
+// handleUpdateCategory changes the stage, priority or hardcoded flag of a
+// category. In flat files these are part of the key, so the category is renamed
+// in place (and so are the settings that refer to it).
+func handleUpdateCategory(c echo.Context) error {
+	campaign, key := c.Param("campaign"), c.Param("category")
+
+	var req CategoryEditRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if req.Stage != nil && !stageRegex.MatchString(*req.Stage) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid stage format. Must be s1, s2, s3, etc."})
+	}
+	if req.Priority != nil && *req.Priority < 1 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "priority must be 1 or higher"})
+	}
+
+	newKey := key
+	data, err := editCampaign(campaign, c.Request().Header.Get("If-Match"), func(doc *campaignDocument) error {
+		categories, err := doc.categories()
+		if err != nil {
+			return err
+		}
+		if !doc.isCategoryKey(categories, key) {
+			return doc.categoryNotFound(http.StatusConflict, key)
+		}
+
+		if doc.version != 2 {
+			info := parseCategoryName(key)
+			if info == nil {
+				return newEditError(http.StatusUnprocessableEntity, "Could not parse category name: %s", key)
+			}
+			if req.Stage != nil {
+				info.Stage = *req.Stage
+			}
+			if req.Hardcoded != nil {
+				info.IsHardcoded = *req.Hardcoded
+			}
+			if req.Priority != nil {
+				info.Priority = *req.Priority
+			}
+			if !info.IsHardcoded && info.Priority < 1 {
+				return newEditError(http.StatusBadRequest, "priority is required when a category stops being hardcoded")
+			}
+			newKey = flatCategoryKey(info.BaseName, info.Priority, info.IsHardcoded, info.Stage)
+			if newKey == key {
+				return nil
+			}
+			if _, exists := categories.get(newKey); exists {
+				return newEditError(http.StatusConflict, "Category already exists: %s", newKey)
+			}
+			categories.rename(key, newKey)
+			return doc.renameSettingsReferences(key, newKey)
+		}
+
+		category := categories.child(key)
+		if category == nil {
+			return newEditError(http.StatusUnprocessableEntity, "Category %s is not an object", key)
+		}
+		if req.Stage != nil {
+			category.set("stage", *req.Stage)
+		}
+		if req.Hardcoded != nil {
+			if *req.Hardcoded {
+				category.set("hardcoded", true)
+			} else {
+				category.remove("hardcoded")
+			}
+		}
+		if req.Priority != nil {
+			category.set("priority", *req.Priority)
+		}
+		if err := categories.set(key, category); err != nil {
+			return err
+		}
+		return doc.setCategories(categories)
+	})
+
+	return editResponse(c, http.StatusOK, campaign, newKey, data, err)
+}
+
+func handleDeleteCategory(c echo.Context) error {
+	campaign, key := c.Param("campaign"), c.Param("category")
+
+	data, err := editCampaign(campaign, c.Request().Header.Get("If-Match"), func(doc *campaignDocument) error {
+		categories, err := doc.categories()
+		if err != nil {
+			return err
+		}
+		if !doc.isCategoryKey(categories, key) {
+			return doc.categoryNotFound(http.StatusConflict, key)
+		}
+		categories.remove(key)
+		if err := doc.setCategories(categories); err != nil {
+			return err
+		}
+		return doc.renameSettingsReferences(key, "")
+	})
+
+	return editResponse(c, http.StatusOK, campaign, "", data, err)
+}
+
+// handleAddKeywords appends keywords to a category; keywords already in the
+// list (after normalization) are left alone
+func handleAddKeywords(c echo.Context) error {
+	campaign, key := c.Param("campaign"), c.Param("category")
+
+	var req KeywordsEditRequest
+	if err := c.Bind(&req); err != nil || len(req.Keywords) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "keywords must be a non-empty list"})
+	}
+
+	data, err := editCampaign(campaign, c.Request().Header.Get("If-Match"), func(doc *campaignDocument) error {
+		categories, err := doc.categories()
+		if err != nil {
+			return err
+		}
+		keywords, store, err := doc.keywordsOf(categories, key)
+		if err != nil {
+			return err
+		}
+
+		existing := make(map[string]bool, len(keywords))
+		for _, raw := range keywords {
+			var kw string
+			if json.Unmarshal(raw, &kw) == nil {
+				existing[doc.keywordKey(kw)] = true
+			}
+		}
+		for _, kw := range req.Keywords {
+			kw = strings.TrimSpace(kw)
+			if kw == "" || existing[doc.keywordKey(kw)] {
+				continue
+			}
+			existing[doc.keywordKey(kw)] = true
+			raw, err := marshalJSON(kw)
+			if err != nil {
+				return err
+			}
+			keywords = append(keywords, raw)
+		}
+		return store(keywords)
+	})
+
+	return editResponse(c, http.StatusOK, campaign, key, data, err)
+}
+
+// handleRemoveKeywords removes keywords from a category
+// Responds 404 if none of the keywords is in the category.
+func handleRemoveKeywords(c echo.Context) error {
+	campaign, key := c.Param("campaign"), c.Param("category")
+
+	var req KeywordsEditRequest
+	if err := c.Bind(&req); err != nil || len(req.Keywords) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "keywords must be a non-empty list"})
+	}
+
+	data, err := editCampaign(campaign, c.Request().Header.Get("If-Match"), func(doc *campaignDocument) error {
+		categories, err := doc.categories()
+		if err != nil {
+			return err
+		}
+		keywords, store, err := doc.keywordsOf(categories, key)
+		if err != nil {
+			return err
+		}
+
+		remove := make(map[string]bool, len(req.Keywords))
+		for _, kw := range req.Keywords {
+			remove[strings.TrimSpace(kw)] = true
+		}
+		kept := make([]json.RawMessage, 0, len(keywords))
+		for _, raw := range keywords {
+			var kw string
+			if json.Unmarshal(raw, &kw) == nil && remove[kw] {
+				continue
+			}
+			kept = append(kept, raw)
+		}
+		if len(kept) == len(keywords) {
+			return newEditError(http.StatusNotFound, "None of the keywords are in category %s", key)
+		}
+		return store(kept)
+	})
+
+	return editResponse(c, http.StatusOK, campaign, key, data, err)
+}
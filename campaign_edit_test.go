@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// useTestCampaignCache serves the campaigns of dir for the rest of the test
+func useTestCampaignCache(t *testing.T, dir string) {
+	t.Helper()
+	cc, err := NewCampaignCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := campaignCache
+	campaignCache = cc
+	t.Cleanup(func() {
+		campaignCache = previous
+		cc.Close()
+	})
+}
+
+// newTestEditServer serves the campaign edit API over dir, without auth
+func newTestEditServer(t *testing.T, dir string) *echo.Echo {
+	t.Helper()
+	useTestCampaignCache(t, dir)
+
+	e := echo.New()
+	e.GET("/admin/campaigns/:campaign/categories", handleListCategories)
+	e.POST("/admin/campaigns/:campaign/categories", handleCreateCategory)
+	e.GET("/admin/campaigns/:campaign/categories/:category", handleGetCategory)
+	e.PATCH("/admin/campaigns/:campaign/categories/:category", handleUpdateCategory)
+	e.DELETE("/admin/campaigns/:campaign/categories/:category", handleDeleteCategory)
+	e.POST("/admin/campaigns/:campaign/categories/:category/keywords", handleAddKeywords)
+	e.DELETE("/admin/campaigns/:campaign/categories/:category/keywords", handleRemoveKeywords)
+	return e
+}
+
+// editRequest sends one request to the edit API
+func editRequest(e *echo.Echo, method, path, ifMatch, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// fileETag is the ETag of a campaign file as it is on disk
+func fileETag(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return campaignETag(data)
+}
+
+func TestEditCampaignPreconditions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "campaign.json")
+	writeTestFile(t, path, `{"busy_p1_s1": ["busy"]}`)
+	e := newTestEditServer(t, dir)
+	etag := fileETag(t, path)
+
+	tests := []struct {
+		ifMatch string
+		status  int
+	}{
+		{"", http.StatusPreconditionRequired},
+		{"*", http.StatusPreconditionRequired},
+		{`"0000"`, http.StatusPreconditionFailed},
+		{etag, http.StatusOK},
+		{etag, http.StatusPreconditionFailed}, // Stale after the previous edit
+	}
+	for _, tt := range tests {
+		rec := editRequest(e, http.MethodPost, "/admin/campaigns/campaign/categories/busy_p1_s1/keywords", tt.ifMatch, `{"keywords": ["call later"]}`)
+		if rec.Code != tt.status {
+			t.Errorf("If-Match %q: status %d, want %d (%s)", tt.ifMatch, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
+
+// Keys keep their file order, and the response carries the new ETag
+func TestEditCampaignKeepsKeyOrder(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "campaign.json")
+	writeTestFile(t, path, `{
+  "zulu_p2_s1": ["zulu"],
+  "settings": {"match_modes": {"zulu_p2_s1": ["fuzzy"]}},
+  "alpha_p1_s1": ["alpha"],
+  "mike_p3_s1": ["mike"]
+}
+`)
+	e := newTestEditServer(t, dir)
+
+	rec := editRequest(e, http.MethodPost, "/admin/campaigns/campaign/categories/alpha_p1_s1/keywords", fileETag(t, path), `{"keywords": ["bravo"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if got, want := rec.Header().Get("ETag"), fileETag(t, path); got != want {
+		t.Errorf("ETag %s, want %s", got, want)
+	}
+
+	data, _ := os.ReadFile(path)
+	raw, order, err := parseCampaignData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"zulu_p2_s1", "settings", "alpha_p1_s1", "mike_p3_s1"}; !slices.Equal(order[""], want) {
+		t.Errorf("key order %v, want %v", order[""], want)
+	}
+	if got := raw["alpha_p1_s1"]; len(got.([]interface{})) != 2 {
+		t.Errorf("alpha_p1_s1 = %v, want bravo appended", got)
+	}
+	if !strings.HasPrefix(string(data), "{\n  \"zulu_p2_s1\"") || !strings.HasSuffix(string(data), "]\n}\n") {
+		t.Errorf("file lost its indentation or trailing newline:\n%s", data)
+	}
+}
+
+func TestEditCampaignVersion2(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "campaign.json")
+	writeTestFile(t, path, testV2Base)
+	e := newTestEditServer(t, dir)
+
+	rec := editRequest(e, http.MethodPost, "/admin/campaigns/campaign/categories", fileETag(t, path),
+		`{"name": "busy", "stage": "s1", "priority": 2, "return_value": "callback", "keywords": ["call me later"]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", rec.Code, rec.Body)
+	}
+	rec = editRequest(e, http.MethodPatch, "/admin/campaigns/campaign/categories/busy", fileETag(t, path), `{"priority": 3}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update: status %d: %s", rec.Code, rec.Body)
+	}
+
+	var category CampaignCategory
+	if err := json.Unmarshal(rec.Body.Bytes(), &category); err != nil {
+		t.Fatal(err)
+	}
+	if category.Key != "busy" || category.Priority != 3 || category.ReturnValue != "callback" {
+		t.Errorf("category = %+v, want busy at priority 3 returning callback", category)
+	}
+
+	data, _ := os.ReadFile(path)
+	_, order, _ := parseCampaignData(data)
+	if want := []string{"stage", "priority", "return_value", "keywords"}; !slices.Equal(order["categories/busy"], want) {
+		t.Errorf("busy members %v, want %v", order["categories/busy"], want)
+	}
+	if got := campaignCache.matchers["campaign"].ProcessStage("call me later", "s1"); got != "callback" {
+		t.Errorf("reloaded campaign matched %q, want callback", got)
+	}
+}
+
+// Renaming a flat category updates the negation rules that refer to it
+func TestEditCampaignRenameUpdatesNegationOpposite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "campaign.json")
+	writeTestFile(t, path, `{
+		"settings": {"negation": {"categories": {"interested_p2_s1": {"action": "reroute", "opposite": "notInterested_p1_s1"}}}},
+		"notInterested_p1_s1": ["not for me"],
+		"interested_p2_s1": ["interested"]
+	}`)
+	e := newTestEditServer(t, dir)
+
+	rec := editRequest(e, http.MethodPatch, "/admin/campaigns/campaign/categories/notInterested_p1_s1", fileETag(t, path), `{"priority": 3}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	data, _ := os.ReadFile(path)
+	var file struct {
+		Settings CampaignSettings `json:"settings"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	if got := file.Settings.Negation.Categories["interested_p2_s1"].Opposite; got != "notInterested_p3_s1" {
+		t.Errorf("opposite = %q, want notInterested_p3_s1", got)
+	}
+	if got := campaignCache.matchers["campaign"].ProcessStage("i am not interested", "s1"); got != "notinterested" {
+		t.Errorf("negated hit matched %q, want it rerouted to notinterested", got)
+	}
+}
+
+// Keywords that normalize to one already in the category are not added
+func TestAddKeywordsDeduplicatesNormalized(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "campaign.json")
+	writeTestFile(t, path, `{"busy_p1_s1": ["i am busy"]}`)
+	e := newTestEditServer(t, dir)
+
+	rec := editRequest(e, http.MethodPost, "/admin/campaigns/campaign/categories/busy_p1_s1/keywords", fileETag(t, path),
+		`{"keywords": ["I'm busy", " I AM BUSY ", "driving", "Driving"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var category CampaignCategory
+	if err := json.Unmarshal(rec.Body.Bytes(), &category); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"i am busy", "driving"}; !slices.Equal(category.Keywords, want) {
+		t.Errorf("keywords = %q, want %q", category.Keywords, want)
+	}
+}
+
+// Categories from a base campaign are reported as inherited instead of missing
+func TestEditInheritedCategory(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "base.json"), `{"busy_p1_s1": ["busy"]}`)
+	path := filepath.Join(dir, "campaign.json")
+	writeTestFile(t, path, `{"extends": "base", "interested_p2_s1": ["interested"]}`)
+	e := newTestEditServer(t, dir)
+
+	rec := editRequest(e, http.MethodPost, "/admin/campaigns/campaign/categories/busy_p1_s1/keywords", fileETag(t, path), `{"keywords": ["driving"]}`)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "inherited from base.json") {
+		t.Errorf("add to inherited category: status %d: %s", rec.Code, rec.Body)
+	}
+	rec = editRequest(e, http.MethodGet, "/admin/campaigns/campaign/categories/busy_p1_s1", "", "")
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "inherited from base.json") {
+		t.Errorf("get inherited category: status %d: %s", rec.Code, rec.Body)
+	}
+	rec = editRequest(e, http.MethodDelete, "/admin/campaigns/campaign/categories/extends", fileETag(t, path), "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("delete extends: status %d, want 404: %s", rec.Code, rec.Body)
+	}
+	rec = editRequest(e, http.MethodDelete, "/admin/campaigns/campaign/categories/missing_p1_s1", fileETag(t, path), "")
+	if rec.Code != http.StatusNotFound || strings.Contains(rec.Body.String(), "inherited") {
+		t.Errorf("delete missing category: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+// Files that are not valid campaign JSON are rejected before any edit
+func TestEditInvalidCampaignFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "campaign.json")
+	writeTestFile(t, path, `{"busy_p1_s1": ["busy"], "version": "two"}`)
+	e := newTestEditServer(t, dir)
+
+	rec := editRequest(e, http.MethodPost, "/admin/campaigns/campaign/categories/busy_p1_s1/keywords", fileETag(t, path), `{"keywords": ["driving"]}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status %d, want 422: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "campaign.json")
+	writeTestFile(t, path, "old")
+	if err := os.Chmod(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(path, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	info, _ := os.Stat(path)
+	if string(data) != "new" || info.Mode().Perm() != 0o600 {
+		t.Errorf("file = %q with mode %v, want %q with mode 0600", data, info.Mode().Perm(), "new")
+	}
+
+	// The temp file is gone after a rename, and after a failed one
+	writeTestFile(t, filepath.Join(dir, "taken.json", "file"), "")
+	if err := writeFileAtomic(filepath.Join(dir, "taken.json"), []byte("new")); err == nil {
+		t.Errorf("replacing a directory should fail")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("directory holds %d entries, want only campaign.json and taken.json", len(entries))
+	}
+}
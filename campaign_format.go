@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Reserved top-level keys of a campaign file
+const (
+	versionKey    = "version"
+	categoriesKey = "categories"
+)
+
+// categoryDefinition is one category as written in a campaign file, independent
+// of the file format it came from
+type categoryDefinition struct {
+	Info       CategoryInfo
+	Keywords   []string
+	Excludes   []string
+	MatchModes []string
+
+	// JSON paths inside the campaign file (see jsonPath), used to report positions
+	Path         string
+	KeywordsPath string
+	ExcludesPath string
+}
+
+// CategoryDefinitionV2 is a category object of a version 2 campaign file
+// Example:
+//
+//	{
+//	  "version": 2,
+//	  "settings": {...},
+//	  "categories": {
+//	    "interested_s1": {
+//	      "stage": "s1",
+//	      "priority": 6,
+//	      "return_value": "interested",
+//	      "match_modes": ["fuzzy"],
+//	      "keywords": ["i am fine", "doing great"],
+//	      "exclude_keywords": ["not fine"],
+//	      "description": "Caller is open to the pitch"
+//	    }
+//	  }
+//	}
+type CategoryDefinitionV2 struct {
+	Stage           string   `json:"stage"`
+	Priority        int      `json:"priority"`
+	Hardcoded       bool     `json:"hardcoded"`
+	ReturnValue     string   `json:"return_value"` // Default: the key without its priority and stage suffixes, lowercased
+	MatchModes      []string `json:"match_modes"`
+	Keywords        []string `json:"keywords"`
+	ExcludeKeywords []string `json:"exclude_keywords"`
+	Description     string   `json:"description"`
+}
+
+// KeyOrder records the order in which object keys appear in a campaign file,
+// keyed by the JSON path of the object ("" for the top level, see jsonPath).
+// Go maps lose that order, and categories that share a priority are checked in
+// file order.
+type KeyOrder map[string][]string
+
+// parseCampaignData decodes a campaign file and records its key order
+func parseCampaignData(data []byte) (FlexibleKeywordSets, KeyOrder, error) {
+	var rawKeywords FlexibleKeywordSets
+	if err := json.Unmarshal(data, &rawKeywords); err != nil {
+		return nil, nil, err
+	}
+
+	order := make(KeyOrder)
+	err := walkJSON(data, func(path, parent string, isKey bool, offset int) {
+		if isKey {
+			order[parent] = append(order[parent], path[strings.LastIndex(path, "/")+1:])
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rawKeywords, order, nil
+}
+
+// orderedKeys returns the keys of m in file order; keys missing from order
+// (e.g. maps built in code) follow in alphabetical order
+func orderedKeys(m map[string]interface{}, order []string) []string {
+	keys := make([]string, 0, len(m))
+	listed := make(map[string]bool, len(order))
+	for _, key := range order {
+		if _, ok := m[key]; ok && !listed[key] {
+			listed[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	rest := make([]string, 0, len(m)-len(keys))
+	for key := range m {
+		if !listed[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+
+	return append(keys, rest...)
+}
+
+// walkJSON calls visit for every object member and array element of data with
+// its JSON path, the path of its parent, whether it is an object member, and the
+// byte offset where it starts (the key for object members). Keys containing "/"
+// are not escaped.
+func walkJSON(data []byte, visit func(path, parent string, isKey bool, offset int)) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	// Offset of the next token (InputOffset may point at whitespace or separators)
+	nextOffset := func() int {
+		offset := int(dec.InputOffset())
+		for offset < len(data) && strings.IndexByte(" \t\r\n:,", data[offset]) >= 0 {
+			offset++
+		}
+		return offset
+	}
+	child := func(path, key string) string {
+		if path == "" {
+			return key
+		}
+		return jsonPath(path, key)
+	}
+
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				offset := nextOffset()
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				memberPath := child(path, fmt.Sprint(key))
+				visit(memberPath, path, true, offset)
+				if err := walk(memberPath); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				elementPath := child(path, strconv.Itoa(i))
+				visit(elementPath, path, false, nextOffset())
+				if err := walk(elementPath); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+
+	return walk("")
+}
+
+// campaignFormatVersion returns the schema version of a campaign file
+// Files without a "version" field use the original flat format (version 1).
+// ok is false when the version field holds an unsupported value.
+func campaignFormatVersion(rawKeywords FlexibleKeywordSets) (version int, ok bool) {
+	value, exists := rawKeywords[versionKey]
+	if !exists {
+		return 1, true
+	}
+	v, isNumber := value.(float64)
+	if !isNumber || (v != 1 && v != 2) {
+		return 1, false
+	}
+	return int(v), true
+}
+
+// categoryDefinitions extracts every category of a campaign file in either format
+// Definitions are returned in file order.
+func (km *KeywordMatcher) categoryDefinitions(rawKeywords FlexibleKeywordSets, order KeyOrder) []categoryDefinition {
+	version, ok := campaignFormatVersion(rawKeywords)
+	if !ok {
+		km.warnf(versionKey, "Unsupported campaign file version %v, using flat format", rawKeywords[versionKey])
+	}
+	if version == 2 {
+		return km.structuredCategoryDefinitions(rawKeywords[categoriesKey], order[categoriesKey])
+	}
+	return km.flatCategoryDefinitions(rawKeywords, order[""])
+}
+
+// flatCategoryDefinitions parses the original format where every top-level key
+// is a category following the pattern {category}_{priority}_{stage}
+func (km *KeywordMatcher) flatCategoryDefinitions(rawKeywords FlexibleKeywordSets, order []string) []categoryDefinition {
+	definitions := make([]categoryDefinition, 0, len(rawKeywords))
+
+	for _, categoryKey := range orderedKeys(rawKeywords, order) {
+		value := rawKeywords[categoryKey]
+		if categoryKey == settingsKey || categoryKey == versionKey || isInheritanceKey(categoryKey) {
+			continue
+		}
+
+		info := parseCategoryName(categoryKey)
+		if info == nil {
+			km.warnf(categoryKey, "Could not parse category name: %s", categoryKey)
+			continue
+		}
+
+		definitions = append(definitions, categoryDefinition{
+			Info:         *info,
+			Keywords:     km.convertToStringSlice(value),
+			MatchModes:   km.settings.MatchModes[categoryKey],
+			Path:         categoryKey,
+			KeywordsPath: categoryKey,
+		})
+	}
+
+	return definitions
+}
+
+// structuredCategoryDefinitions parses the "categories" object of a version 2 file
+func (km *KeywordMatcher) structuredCategoryDefinitions(value interface{}, order []string) []categoryDefinition {
+	categories, ok := value.(map[string]interface{})
+	if !ok {
+		km.warnf(categoriesKey, "Version 2 campaign file has no \"categories\" object")
+		return nil
+	}
+
+	definitions := make([]categoryDefinition, 0, len(categories))
+	for _, categoryKey := range orderedKeys(categories, order) {
+		rawCategory := categories[categoryKey]
+		var def CategoryDefinitionV2
+		data, err := json.Marshal(rawCategory)
+		if err == nil {
+			err = json.Unmarshal(data, &def)
+		}
+		if err != nil {
+			km.warnf(jsonPath(categoriesKey, categoryKey), "Invalid category %s: %v", categoryKey, err)
+			continue
+		}
+
+		info, msg := def.categoryInfo(categoryKey)
+		if msg != "" {
+			km.warnf(jsonPath(categoriesKey, categoryKey), "Invalid category %s: %s", categoryKey, msg)
+			continue
+		}
+
+		// Modes from the category object and from settings.match_modes both apply
+		modes := append(append([]string{}, def.MatchModes...), km.settings.MatchModes[categoryKey]...)
+
+		path := jsonPath(categoriesKey, categoryKey)
+		definitions = append(definitions, categoryDefinition{
+			Info:         info,
+			Keywords:     def.Keywords,
+			Excludes:     def.ExcludeKeywords,
+			MatchModes:   modes,
+			Path:         path,
+			KeywordsPath: jsonPath(path, "keywords"),
+			ExcludesPath: jsonPath(path, "exclude_keywords"),
+		})
+	}
+
+	return definitions
+}
+
+// categoryInfo validates a version 2 category and converts it to CategoryInfo
+// Returns an error message, or "" if the category is valid
+func (def *CategoryDefinitionV2) categoryInfo(categoryKey string) (CategoryInfo, string) {
+	if !strings.HasPrefix(def.Stage, "s") || len(def.Stage) < 2 {
+		return CategoryInfo{}, "stage must be s1, s2, s3, etc."
+	}
+	if !def.Hardcoded && def.Priority < 1 {
+		return CategoryInfo{}, "priority must be 1 or higher unless hardcoded"
+	}
+
+	info := CategoryInfo{
+		Key:         categoryKey,
+		BaseName:    def.baseName(categoryKey),
+		Priority:    def.Priority,
+		Stage:       def.Stage,
+		IsHardcoded: def.Hardcoded,
+		ReturnValue: def.ReturnValue,
+		Description: def.Description,
+	}
+	if info.IsHardcoded {
+		info.Priority = 0
+	}
+	if info.ReturnValue == "" {
+		info.ReturnValue = generateReturnValue(info.BaseName, def.Stage)
+	}
+
+	return info, ""
+}
+
+// baseName strips the stage suffix, and a priority suffix before it, from the key
+// of a version 2 category, so it gets the same base name and default return
+// value as in the flat format: "interested_s1" and "interested_p6_s1" -> "interested"
+func (def *CategoryDefinitionV2) baseName(categoryKey string) string {
+	base := strings.TrimSuffix(categoryKey, "_"+def.Stage)
+	priorityPart := fmt.Sprintf("p%d", def.Priority)
+	if def.Hardcoded {
+		priorityPart = "hardcoded"
+	}
+	if name := strings.TrimSuffix(base, "_"+priorityPart); name != "" {
+		base = name
+	}
+	if base == "" {
+		return categoryKey
+	}
+	return base
+}
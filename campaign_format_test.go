@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Both file formats must produce the same categories, including the base name
+// and default return value derived from the category key
+func TestVersion2MatchesFlatFormat(t *testing.T) {
+	flat := newTestMatcher(t, `{
+		"interested_p1_s1": ["i am fine"],
+		"doNotCall_hardcoded_s1": ["do not call"],
+		"busy_p2_s1": ["busy"]
+	}`)
+	v2 := newTestMatcher(t, `{
+		"version": 2,
+		"categories": {
+			"interested_s1": {"stage": "s1", "priority": 1, "keywords": ["i am fine"]},
+			"doNotCall_hardcoded_s1": {"stage": "s1", "hardcoded": true, "keywords": ["do not call"]},
+			"busy_p2_s1": {"stage": "s1", "priority": 2, "keywords": ["busy"]}
+		}
+	}`)
+
+	summarize := func(km *KeywordMatcher) []CategoryInfo {
+		var infos []CategoryInfo
+		for _, group := range km.stageMap["s1"].groups {
+			for _, catEntry := range group.categories {
+				info := catEntry.Info
+				info.Key = "" // Keys differ between the two files
+				infos = append(infos, info)
+			}
+		}
+		return infos
+	}
+	if got, want := summarize(v2), summarize(flat); !reflect.DeepEqual(got, want) {
+		t.Errorf("version 2 categories = %+v\nflat categories = %+v", got, want)
+	}
+
+	for text, want := range map[string]string{"i am fine": "interested", "do not call": "donotcall", "busy": "busy"} {
+		if got := v2.ProcessStage(text, "s1"); got != want {
+			t.Errorf("ProcessStage(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
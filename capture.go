@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// CaptureRecord is one line of the capture file written when CAPTURE_FILE is
+// set; the replay subcommand reads these lines back
+type CaptureRecord struct {
+	Time            time.Time `json:"time"`
+	Campaign        string    `json:"campaign"`
+	Stage           string    `json:"stage"`
+	SpeechText      string    `json:"speech_text"`
+	Result          string    `json:"result"`
+	Keyword         string    `json:"keyword,omitempty"`
+	MatchType       string    `json:"match_type,omitempty"`
+	CategoryKey     string    `json:"category_key,omitempty"`
+	CampaignVersion string    `json:"campaign_version"`
+
+	// Set for requests with alternatives, so replay can rerun the policy decision;
+	// SpeechText is then the alternative that produced the result
+	Alternatives      []Alternative `json:"alternatives,omitempty"`
+	Policy            string        `json:"policy,omitempty"`
+	MinWordConfidence float64       `json:"min_word_confidence,omitempty"`
+}
+
+// captureWriter appends JSON lines to a file and rotates it once it grows past
+// maxBytes: path -> path.1 -> path.2 ..., keeping at most maxFiles old files
+type captureWriter struct {
+	sync.Mutex
+	path     string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// capture is nil unless capture mode is enabled
+var capture *captureWriter
+
+func newCaptureWriter(path string, maxBytes int64, maxFiles int) (*captureWriter, error) {
+	cw := &captureWriter{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := cw.open(); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *captureWriter) open() error {
+	file, err := os.OpenFile(cw.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open capture file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open capture file: %w", err)
+	}
+	cw.file = file
+	cw.size = info.Size()
+	return nil
+}
+
+// rotate shifts the old files up by one and starts a new file. Caller holds the lock.
+func (cw *captureWriter) rotate() error {
+	cw.file.Close()
+	cw.file = nil
+
+	os.Remove(fmt.Sprintf("%s.%d", cw.path, cw.maxFiles))
+	for i := cw.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", cw.path, i), fmt.Sprintf("%s.%d", cw.path, i+1))
+	}
+	if cw.maxFiles > 0 {
+		os.Rename(cw.path, cw.path+".1")
+	} else {
+		os.Remove(cw.path)
+	}
+
+	return cw.open()
+}
+
+// write appends one record; capture failures are logged and never fail a request
+func (cw *captureWriter) write(record CaptureRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Warning: failed to encode capture record: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	cw.Lock()
+	defer cw.Unlock()
+
+	if cw.file == nil {
+		// A previous rotation failed; try again
+		if err := cw.open(); err != nil {
+			log.Printf("Warning: %v", err)
+			return
+		}
+	}
+	if cw.maxBytes > 0 && cw.size > 0 && cw.size+int64(len(line)) > cw.maxBytes {
+		if err := cw.rotate(); err != nil {
+			log.Printf("Warning: failed to rotate capture file: %v", err)
+			return
+		}
+	}
+
+	n, err := cw.file.Write(line)
+	cw.size += int64(n)
+	if err != nil {
+		log.Printf("Warning: failed to write capture record: %v", err)
+	}
+}
+
+func (cw *captureWriter) Close() {
+	cw.Lock()
+	defer cw.Unlock()
+	if cw.file != nil {
+		cw.file.Close()
+		cw.file = nil
+	}
+}
+
+// captureMatch records a match in the capture file if capture mode is enabled
+func captureMatch(matcher *KeywordMatcher, campaign, text, stage string, result *matchResult) {
+	if capture == nil {
+		return
+	}
+	capture.write(newCaptureRecord(matcher, campaign, text, stage, result))
+}
+
+// captureAlternativesMatch records the decision of a request with alternatives
+// together with the alternatives, its policy and min_word_confidence
+func captureAlternativesMatch(matcher *KeywordMatcher, req *MatchRequest, text string, result *matchResult) {
+	if capture == nil {
+		return
+	}
+	record := newCaptureRecord(matcher, req.Campaign, text, req.Stage, result)
+	record.Alternatives = req.Alternatives
+	record.Policy = req.Policy
+	record.MinWordConfidence = req.MinWordConfidence
+	capture.write(record)
+}
+
+func newCaptureRecord(matcher *KeywordMatcher, campaign, text, stage string, result *matchResult) CaptureRecord {
+	record := CaptureRecord{
+		Time:            time.Now(),
+		Campaign:        campaign,
+		Stage:           stage,
+		SpeechText:      text,
+		Result:          "unknown",
+		CampaignVersion: matcher.version,
+	}
+	if result != nil {
+		record.Result = result.returnValue
+		record.Keyword = result.keyword
+		record.MatchType = result.matchType
+		record.CategoryKey = result.categoryKey
+	}
+	return record
+}
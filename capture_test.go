@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A captured request with alternatives replays to the same policy decision,
+// even when its speech text alone would match something else
+func TestReplayAlternativesCapture(t *testing.T) {
+	km := newTestMatcher(t, `{
+		"busy_p1_s1": ["busy"],
+		"yes_p2_s1": ["yes"]
+	}`)
+
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	cw, err := newCaptureWriter(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture = cw
+	defer func() {
+		capture = nil
+		cw.Close()
+	}()
+
+	req := &MatchRequest{
+		Campaign:     "test",
+		Stage:        "s1",
+		Alternatives: []Alternative{{Text: "yes"}, {Text: "i am busy"}},
+		Policy:       policyAgree,
+	}
+	if msg := validateAlternatives(req); msg != "" {
+		t.Fatal(msg)
+	}
+	if got := matchAlternativesObserved(km, req).returnValue(); got != "unknown" {
+		t.Fatalf("decision = %s, want unknown", got)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record CaptureRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Policy != policyAgree || len(record.Alternatives) != 2 {
+		t.Fatalf("captured policy %q with %d alternatives, want %q with 2", record.Policy, len(record.Alternatives), policyAgree)
+	}
+	if got := returnValueOf(record.replay(km)); got != record.Result {
+		t.Errorf("replay = %s, want the captured %s", got, record.Result)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// matchTypeWeights rate how reliable each match type is on its own
+var matchTypeWeights = map[string]float64{
+	"exact":     1.0,
+	"phrase":    0.9,
+	"substring": 0.8,
+	"fuzzy":     0.6,
+	"phonetic":  0.5,
+}
+
+// Weights of the parts of a match score (see matchScore)
+const (
+	scoreBase            = 0.5 // Any match
+	scoreCoverageWeight  = 0.4 // Fraction of the utterance's words covered by the keyword
+	scoreLengthWeight    = 0.1 // Keyword length, full at scoreLengthWords words
+	scoreLengthWords     = 3
+	scoreCompetitionRate = 0.25 // Penalty per other category hit in the stage
+)
+
+// matchScore rates from 0 to 1 how confidently result describes the utterance:
+//
+//	weight(match type) * (0.5 + 0.4*coverage + 0.1*length) / (1 + 0.25*competing)
+//
+// where coverage is the fraction of the utterance's words covered by the keyword,
+// length grows with the keyword's word count up to three words, and competing
+// is the number of other categories of the stage with a hit. An exact match of
+// the whole utterance with no competition scores above 0.9; "fine" buried in a
+// 40-word utterance that also hits two other categories scores about 0.3.
+func (sc *StageCategories) matchScore(normalized string, result *matchResult, hits []keywordHit) float64 {
+	keywordWords := strings.Count(result.keyword, " ") + 1
+	textWords := strings.Count(normalized, " ") + 1
+	coverage := math.Min(1, float64(keywordWords)/float64(textWords))
+	length := math.Min(1, float64(keywordWords)/scoreLengthWords)
+
+	winner := sc.patterns[result.pattern]
+	competing := make(map[[2]int]bool)
+	for _, hit := range hits {
+		ref := sc.patterns[hit.pattern]
+		if ref.group != winner.group || ref.category != winner.category {
+			competing[[2]int{ref.group, ref.category}] = true
+		}
+	}
+
+	score := matchTypeWeights[result.matchType] *
+		(scoreBase + scoreCoverageWeight*coverage + scoreLengthWeight*length) /
+		(1 + scoreCompetitionRate*float64(len(competing)))
+	return math.Round(score*1000) / 1000
+}
+
+// applyConfidence scores result and, when the score is below the campaign's
+// threshold, replaces its return value with the campaign's low-confidence result
+func (km *KeywordMatcher) applyConfidence(sc *StageCategories, normalized string, result *matchResult, hits []keywordHit) {
+	result.score = sc.matchScore(normalized, result, hits)
+	if result.score < km.settings.Confidence.Threshold {
+		result.lowConfidence = true
+		result.returnValue = km.settings.Confidence.LowResult
+	}
+}
+
+// returnValueOf returns what is answered for a match, "unknown" when nothing matched
+func returnValueOf(result *matchResult) string {
+	if result == nil {
+		return "unknown"
+	}
+	return result.returnValue
+}
+
+// scoreOf returns the score of a match, 0 when nothing matched
+func scoreOf(result *matchResult) float64 {
+	if result == nil {
+		return 0
+	}
+	return result.score
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultFillers are removed when a campaign enables the disfluency filter
+// without listing its own fillers. Words that also carry meaning ("like",
+// "well", "so") are left out; campaigns can add them.
+var defaultFillers = []string{"um", "umm", "uh", "uhh", "uhm", "er", "erm", "ah", "hmm", "mm", "mhm"}
+
+// annotationRegex matches ASR annotations such as "[noise]", "[background speech]" or "<unk>"
+var annotationRegex = regexp.MustCompile(`\[[^\]]*\]|<[^>]*>`)
+
+// prepareFillers lowercases the configured fillers into word lists, longest
+// first so "you know" is removed before "you"
+func (km *KeywordMatcher) prepareFillers() {
+	km.fillers = nil
+	if !km.settings.Disfluency.Enabled {
+		return
+	}
+
+	for i, filler := range km.settings.Disfluency.Fillers {
+		words := strings.Fields(strings.ToLower(filler))
+		for j, word := range words {
+			if expansion, ok := km.contractions[word]; ok {
+				words[j] = expansion
+			}
+		}
+		words = strings.Fields(strings.Join(words, " "))
+		if len(words) == 0 {
+			km.warnf(jsonPath(settingsKey, "disfluency", "fillers", strconv.Itoa(i)), "Empty disfluency filler %q", filler)
+			continue
+		}
+		km.fillers = append(km.fillers, words)
+	}
+	sort.SliceStable(km.fillers, func(i, j int) bool {
+		return len(km.fillers[i]) > len(km.fillers[j])
+	})
+}
+
+// stripAnnotations removes bracketed ASR annotations from text
+func stripAnnotations(text string) string {
+	return annotationRegex.ReplaceAllString(text, " ")
+}
+
+// removeFillers drops every occurrence of a filler from words
+func (km *KeywordMatcher) removeFillers(words []string) []string {
+	out := make([]string, 0, len(words))
+	for i := 0; i < len(words); {
+		if n := km.fillerAt(words, i); n > 0 {
+			i += n
+			continue
+		}
+		out = append(out, words[i])
+		i++
+	}
+	return out
+}
+
+// fillerAt returns the length of the filler starting at words[i], or 0
+func (km *KeywordMatcher) fillerAt(words []string, i int) int {
+	for _, filler := range km.fillers {
+		if i+len(filler) > len(words) {
+			continue
+		}
+		match := true
+		for j, word := range filler {
+			if words[i+j] != word {
+				match = false
+				break
+			}
+		}
+		if match {
+			return len(filler)
+		}
+	}
+	return 0
+}
+
+// collapseRepeats removes immediate repetitions of a word or phrase of up to
+// maxPhraseWords words: "no no no" -> "no", "i am i am not" -> "i am not"
+func collapseRepeats(words []string) []string {
+	out := make([]string, 0, len(words))
+	for _, word := range words {
+		out = append(out, word)
+		for repeated := true; repeated; {
+			repeated = false
+			for n := 1; n <= maxPhraseWords && 2*n <= len(out); n++ {
+				if equalWords(out[len(out)-2*n:len(out)-n], out[len(out)-n:]) {
+					out = out[:len(out)-n]
+					repeated = true
+					break
+				}
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDisfluencyNormalization(t *testing.T) {
+	km := newTestMatcher(t, `{"settings": {"disfluency": {"enabled": true, "fillers": ["um", "uh", "you know"]}}}`)
+
+	tests := []struct {
+		text, want string
+	}{
+		{"um I'm uh busy", "i am busy"},
+		{"[noise] call me <unk> back", "call me back"},
+		{"no no no thanks", "no thanks"},
+		{"i am i am not interested", "i am not interested"},
+		{"you know what", "what"},
+		// Only the listed fillers are dropped
+		{"umm, you see", "umm, you see"},
+	}
+	for _, tt := range tests {
+		if got := km.normalizeText(tt.text); got != tt.want {
+			t.Errorf("normalizeText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestDisfluencyMatching(t *testing.T) {
+	campaign := `{
+		"settings": {"disfluency": {"enabled": %t}},
+		"busy_p1_s1": ["i am busy"],
+		"notInterested_p2_s1": ["no thanks"],
+		"callback_p3_s1": ["call me back"]
+	}`
+
+	enabled := newTestMatcher(t, fmt.Sprintf(campaign, true))
+	checkStageMatches(t, enabled, "s1", []stageMatchCase{
+		{"i am um busy", "busy", "exact"},
+		{"[noise] i am <unk> busy", "busy", "exact"},
+		{"no no no thanks", "notinterested", "exact"},
+		{"uh call me back later", "callback", "phrase"},
+		{"i am very busy", "unknown", ""},
+		// Dropped fillers can turn a phrase into an exact match; between phrases,
+		// the priority level still decides
+		{"um i am busy", "busy", "exact"},
+		{"um no thanks i am busy", "busy", "phrase"},
+	})
+
+	disabled := newTestMatcher(t, fmt.Sprintf(campaign, false))
+	checkStageMatches(t, disabled, "s1", []stageMatchCase{
+		{"i am um busy", "unknown", ""},
+		{"no no no thanks", "notinterested", "phrase"},
+		{"um i am busy", "busy", "phrase"},
+	})
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// EvaluationSample is one labeled utterance of a golden set
+type EvaluationSample struct {
+	Campaign       string `json:"campaign"`
+	Stage          string `json:"stage"`
+	SpeechText     string `json:"speech_text"`
+	ExpectedResult string `json:"expected_result"`
+}
+
+// EvaluationReport is the output of the evaluate subcommand
+type EvaluationReport struct {
+	Total      int               `json:"total"`
+	Correct    int               `json:"correct"`
+	Accuracy   float64           `json:"accuracy"`
+	Categories []CategoryScore   `json:"categories"` // Over every stage
+	Stages     []StageEvaluation `json:"stages"`
+	Errors     []string          `json:"errors,omitempty"` // Skipped samples
+}
+
+// StageEvaluation holds the scores of one campaign stage
+// Confusion[i][j] counts samples expected as Labels[i] and predicted as Labels[j].
+type StageEvaluation struct {
+	Campaign   string          `json:"campaign"`
+	Stage      string          `json:"stage"`
+	Total      int             `json:"total"`
+	Correct    int             `json:"correct"`
+	Accuracy   float64         `json:"accuracy"`
+	MacroF1    float64         `json:"macro_f1"`
+	Labels     []string        `json:"labels"`
+	Confusion  [][]int         `json:"confusion"`
+	Categories []CategoryScore `json:"categories"`
+}
+
+// CategoryScore is the precision, recall and F1 of one result value
+type CategoryScore struct {
+	Category      string  `json:"category"`
+	Support       int     `json:"support"`   // Samples expected as this category
+	Predicted     int     `json:"predicted"` // Samples predicted as this category
+	TruePositives int     `json:"true_positives"`
+	Precision     float64 `json:"precision"`
+	Recall        float64 `json:"recall"`
+	F1            float64 `json:"f1"`
+}
+
+// confusionCounts accumulates expected/predicted pairs
+type confusionCounts map[[2]string]int
+
+func (cc confusionCounts) labels() []string {
+	seen := make(map[string]bool)
+	for pair := range cc {
+		seen[pair[0]] = true
+		seen[pair[1]] = true
+	}
+	labels := make([]string, 0, len(seen))
+	for label := range seen {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+func (cc confusionCounts) scores() ([]CategoryScore, int, int) {
+	total, correct := 0, 0
+	byLabel := make(map[string]*CategoryScore)
+	score := func(label string) *CategoryScore {
+		s, ok := byLabel[label]
+		if !ok {
+			s = &CategoryScore{Category: label}
+			byLabel[label] = s
+		}
+		return s
+	}
+	for pair, n := range cc {
+		total += n
+		score(pair[0]).Support += n
+		score(pair[1]).Predicted += n
+		if pair[0] == pair[1] {
+			correct += n
+			score(pair[0]).TruePositives += n
+		}
+	}
+
+	scores := make([]CategoryScore, 0, len(byLabel))
+	for _, label := range cc.labels() {
+		s := byLabel[label]
+		s.Precision = ratio(s.TruePositives, s.Predicted)
+		s.Recall = ratio(s.TruePositives, s.Support)
+		if s.Precision+s.Recall > 0 {
+			s.F1 = 2 * s.Precision * s.Recall / (s.Precision + s.Recall)
+		}
+		scores = append(scores, *s)
+	}
+	return scores, total, correct
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+// runEvaluate implements the "evaluate" subcommand.
+// Every sample of a labeled dataset is matched with ProcessStage against the
+// campaigns of the keywords directory, and the results are compared with the
+// expected ones: a confusion matrix plus precision, recall and F1 per category,
+// for every campaign stage and over all stages. Datasets are CSV files (with a
+// campaign,stage,speech_text,expected_result header) or JSONL files of
+// EvaluationSample; see resultForLabel for the accepted labels.
+//
+//	./main evaluate golden.csv
+//	./main evaluate -json golden.jsonl > report.json
+func runEvaluate(args []string) int {
+	fs := flag.NewFlagSet("evaluate", flag.ContinueOnError)
+	keywordsDir := fs.String("keywords", "keywords", "keywords directory")
+	jsonOut := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "evaluate: no dataset given")
+		return 2
+	}
+
+	// Loading logs every warning; evaluate only reports scores
+	log.SetOutput(io.Discard)
+
+	var samples []EvaluationSample
+	for _, file := range fs.Args() {
+		loaded, err := readEvaluationSamples(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "evaluate: %s: %v\n", file, err)
+			return 2
+		}
+		samples = append(samples, loaded...)
+	}
+
+	report := evaluateSamples(samples, *keywordsDir)
+
+	if *jsonOut {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "evaluate: %v\n", err)
+			return 1
+		}
+	} else {
+		printEvaluationReport(os.Stdout, report)
+	}
+
+	if report.Total == 0 {
+		return 1
+	}
+	return 0
+}
+
+// evaluateSamples matches every sample and scores the results
+func evaluateSamples(samples []EvaluationSample, keywordsDir string) *EvaluationReport {
+	report := &EvaluationReport{
+		Categories: make([]CategoryScore, 0),
+		Stages:     make([]StageEvaluation, 0),
+	}
+
+	matchers := make(map[string]*KeywordMatcher)
+	loadErrors := make(map[string]error)
+	overall := make(confusionCounts)
+	stages := make(map[[2]string]confusionCounts) // campaign, stage -> counts
+
+	for i, sample := range samples {
+		if msg := validateMatchRequest(&MatchRequest{Campaign: sample.Campaign, SpeechText: sample.SpeechText, Stage: sample.Stage}); msg != "" {
+			report.Errors = append(report.Errors, fmt.Sprintf("sample %d: %s", i+1, msg))
+			continue
+		}
+
+		matcher, ok := matchers[sample.Campaign]
+		if !ok {
+			err, failed := loadErrors[sample.Campaign]
+			if !failed {
+				matcher, err = loadKeywordMatcher(filepath.Join(keywordsDir, sample.Campaign+".json"))
+				if err == nil {
+					matchers[sample.Campaign] = matcher
+				} else {
+					loadErrors[sample.Campaign] = err
+				}
+			}
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("sample %d: %v", i+1, err))
+				continue
+			}
+		}
+
+		expected := matcher.resultForLabel(sample.Stage, strings.TrimSpace(sample.ExpectedResult))
+		predicted := matcher.ProcessStage(sample.SpeechText, sample.Stage)
+
+		pair := [2]string{expected, predicted}
+		overall[pair]++
+		key := [2]string{sample.Campaign, sample.Stage}
+		if stages[key] == nil {
+			stages[key] = make(confusionCounts)
+		}
+		stages[key][pair]++
+	}
+
+	report.Categories, report.Total, report.Correct = overall.scores()
+	report.Accuracy = ratio(report.Correct, report.Total)
+
+	keys := make([][2]string, 0, len(stages))
+	for key := range stages {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	for _, key := range keys {
+		counts := stages[key]
+		se := StageEvaluation{Campaign: key[0], Stage: key[1], Labels: counts.labels()}
+		se.Categories, se.Total, se.Correct = counts.scores()
+		se.Accuracy = ratio(se.Correct, se.Total)
+
+		index := make(map[string]int, len(se.Labels))
+		for i, label := range se.Labels {
+			index[label] = i
+		}
+		se.Confusion = make([][]int, len(se.Labels))
+		for i := range se.Confusion {
+			se.Confusion[i] = make([]int, len(se.Labels))
+		}
+		for pair, n := range counts {
+			se.Confusion[index[pair[0]]][index[pair[1]]] = n
+		}
+
+		// Macro F1 over the categories that appear in the expected results
+		labelled := 0
+		for _, s := range se.Categories {
+			if s.Support > 0 {
+				se.MacroF1 += s.F1
+				labelled++
+			}
+		}
+		if labelled > 0 {
+			se.MacroF1 /= float64(labelled)
+		}
+
+		report.Stages = append(report.Stages, se)
+	}
+
+	return report
+}
+
+// resultForLabel maps an expected label to the result ProcessStage returns for it.
+// Labels may be written as results, category names or full category keys
+// (e.g. "notinterested", "notInterested" or "notInterested_p8_s1").
+func (km *KeywordMatcher) resultForLabel(stage, label string) string {
+	if label == "" {
+		return "unknown"
+	}
+	if stageData, exists := km.stageMap[stage]; exists {
+		for _, list := range [][]CategoryEntry{stageData.Hardcoded, stageData.Prioritized} {
+			for _, catEntry := range list {
+				info := catEntry.Info
+				if label == info.ReturnValue || label == info.Key || strings.EqualFold(label, info.BaseName) {
+					return info.ReturnValue
+				}
+			}
+		}
+	}
+	return label
+}
+
+// readEvaluationSamples reads a CSV (by extension) or JSONL dataset
+func readEvaluationSamples(file string) ([]EvaluationSample, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(file), ".csv") {
+		return readEvaluationCSV(f)
+	}
+
+	var samples []EvaluationSample
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var sample EvaluationSample
+		if err := json.Unmarshal([]byte(text), &sample); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
+
+func readEvaluationCSV(r io.Reader) ([]EvaluationSample, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("missing header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"campaign", "stage", "speech_text", "expected_result"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("header has no %s column", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i := columns[name]; i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	var samples []EvaluationSample
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return samples, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, EvaluationSample{
+			Campaign:       field(record, "campaign"),
+			Stage:          field(record, "stage"),
+			SpeechText:     field(record, "speech_text"),
+			ExpectedResult: field(record, "expected_result"),
+		})
+	}
+}
+
+// printEvaluationReport prints the confusion matrix and scores of every stage,
+// then the scores over all stages
+func printEvaluationReport(w io.Writer, report *EvaluationReport) {
+	for _, se := range report.Stages {
+		fmt.Fprintf(w, "== %s %s: %d samples, accuracy %.3f, macro F1 %.3f\n\n", se.Campaign, se.Stage, se.Total, se.Accuracy, se.MacroF1)
+
+		// Confusion matrix: rows are expected, columns predicted
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprint(tw, "expected \\ predicted\t")
+		for _, label := range se.Labels {
+			fmt.Fprintf(tw, "%s\t", label)
+		}
+		fmt.Fprintln(tw)
+		for i, label := range se.Labels {
+			fmt.Fprintf(tw, "%s\t", label)
+			for _, n := range se.Confusion[i] {
+				fmt.Fprintf(tw, "%d\t", n)
+			}
+			fmt.Fprintln(tw)
+		}
+		tw.Flush()
+		fmt.Fprintln(w)
+
+		printCategoryScores(w, se.Categories)
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "== all stages: %d samples, accuracy %.3f\n\n", report.Total, report.Accuracy)
+	printCategoryScores(w, report.Categories)
+
+	for _, msg := range report.Errors {
+		fmt.Fprintf(w, "skipped %s\n", msg)
+	}
+}
+
+func printCategoryScores(w io.Writer, scores []CategoryScore) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "category\tsupport\tpredicted\tprecision\trecall\tf1\t")
+	for _, s := range scores {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.3f\t%.3f\t%.3f\t\n", s.Category, s.Support, s.Predicted, s.Precision, s.Recall, s.F1)
+	}
+	tw.Flush()
+}
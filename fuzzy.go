@@ -0,0 +1,194 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// bkTree is a Burkhard-Keller tree over words using Levenshtein distance.
+// A query only descends into children whose edge distance is within the radius
+// of the query distance (triangle inequality), so lookups touch a small part of
+// the vocabulary instead of comparing against every keyword word.
+type bkTree struct {
+	root *bkNode
+}
+
+type bkNode struct {
+	word     string
+	children map[int]*bkNode
+}
+
+// add inserts word into the tree (duplicates are ignored)
+func (t *bkTree) add(word string) {
+	if t.root == nil {
+		t.root = &bkNode{word: word}
+		return
+	}
+
+	node := t.root
+	for {
+		d := levenshtein(word, node.word)
+		if d == 0 {
+			return
+		}
+		child, ok := node.children[d]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode)
+			}
+			node.children[d] = &bkNode{word: word}
+			return
+		}
+		node = child
+	}
+}
+
+// query calls fn for every word within maxDistance of word
+func (t *bkTree) query(word string, maxDistance int, fn func(match string, distance int)) {
+	if t.root == nil {
+		return
+	}
+
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		d := levenshtein(word, node.word)
+		if d <= maxDistance {
+			fn(node.word, d)
+		}
+		for edge, child := range node.children {
+			if edge >= d-maxDistance && edge <= d+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+}
+
+// levenshtein returns the edit distance between a and b, counted in runes
+func levenshtein(a, b string) int {
+	if a == b {
+		return 0
+	}
+	ra := []rune(a)
+	rb := []rune(b)
+	if len(ra) == 0 {
+		return len(rb)
+	}
+	if len(rb) == 0 {
+		return len(ra)
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// fuzzyIndex finds keywords of fuzzy-enabled categories whose words are each
+// within the allowed edit distance of consecutive words of the text
+type fuzzyIndex struct {
+	tree     *bkTree
+	words    map[string][]fuzzyWordRef // keyword word -> where it appears
+	patterns map[int][]string          // pattern id -> keyword words
+	settings FuzzySettings
+}
+
+// fuzzyWordRef locates a word inside a fuzzy keyword
+type fuzzyWordRef struct {
+	pattern  int
+	position int
+}
+
+func newFuzzyIndex(settings FuzzySettings) *fuzzyIndex {
+	return &fuzzyIndex{
+		tree:     &bkTree{},
+		words:    make(map[string][]fuzzyWordRef),
+		patterns: make(map[int][]string),
+		settings: settings,
+	}
+}
+
+// add registers the keyword of a pattern
+func (fi *fuzzyIndex) add(pattern int, keyword string) {
+	words := strings.Fields(keyword)
+	fi.patterns[pattern] = words
+	for i, w := range words {
+		if _, ok := fi.words[w]; !ok {
+			fi.tree.add(w)
+		}
+		fi.words[w] = append(fi.words[w], fuzzyWordRef{pattern: pattern, position: i})
+	}
+}
+
+// scan reports every window of words where a fuzzy keyword matches, with the
+// index of the first word and the total edit distance.
+// Each keyword word must be within allowedDistance of the aligned text word;
+// windows with distance 0 are literal hits and are not reported.
+func (fi *fuzzyIndex) scan(words []string, emit func(pattern, start, distance int)) {
+	if len(fi.patterns) == 0 || len(words) == 0 {
+		return
+	}
+
+	// Distance from each text word to every close keyword word
+	near := make([]map[string]int, len(words))
+	for i, w := range words {
+		fi.tree.query(w, fi.settings.MaxDistance, func(match string, d int) {
+			if d > fi.settings.allowedDistance(utf8.RuneCountInString(match)) {
+				return
+			}
+			if near[i] == nil {
+				near[i] = make(map[string]int)
+			}
+			near[i][match] = d
+		})
+	}
+
+	// Every close word anchors a candidate window for the keywords containing it
+	type window struct{ pattern, start int }
+	checked := make(map[window]bool)
+
+	for i := range words {
+		for match := range near[i] {
+			for _, ref := range fi.words[match] {
+				kwWords := fi.patterns[ref.pattern]
+				start := i - ref.position
+				if start < 0 || start+len(kwWords) > len(words) {
+					continue
+				}
+				win := window{ref.pattern, start}
+				if checked[win] {
+					continue
+				}
+				checked[win] = true
+
+				total := 0
+				for k, kw := range kwWords {
+					d, ok := near[start+k][kw]
+					if !ok {
+						total = -1
+						break
+					}
+					total += d
+				}
+				if total > 0 {
+					emit(ref.pattern, start, total)
+				}
+			}
+		}
+	}
+}
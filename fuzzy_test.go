@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+// stageMatchCase is the expected best match of a text in one stage
+type stageMatchCase struct {
+	text, result, matchType string
+}
+
+// checkStageMatches peeks every case in stage and compares result and match type
+func checkStageMatches(t *testing.T, km *KeywordMatcher, stage string, tests []stageMatchCase) {
+	t.Helper()
+	for _, tt := range tests {
+		result := km.peekStage(tt.text, stage)
+		got, matchType := returnValueOf(result), ""
+		if result != nil {
+			matchType = result.matchType
+		}
+		if got != tt.result || matchType != tt.matchType {
+			t.Errorf("%q matched %s (%s), want %s (%s)", tt.text, got, matchType, tt.result, tt.matchType)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"busy", "busy", 0},
+		{"", "busy", 4},
+		{"kitten", "sitting", 3},
+		{"interested", "intrested", 1},
+		{"café", "cafe", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestFuzzyMatching(t *testing.T) {
+	km := newTestMatcher(t, `{
+		"settings": {"match_modes": {"interested_p1_s1": ["fuzzy"], "notInterested_p2_s1": ["fuzzy"]}},
+		"interested_p1_s1": ["interested"],
+		"busy_p1_s1": ["busy"],
+		"notInterested_p2_s1": ["no thanks"],
+		"callback_p3_s1": ["call me back"]
+	}`)
+
+	checkStageMatches(t, km, "s1", []stageMatchCase{
+		// Misspellings within the allowed distance
+		{"i am intrested", "interested", "fuzzy"},
+		{"interestd", "interested", "fuzzy"},
+		{"no thank", "notinterested", "fuzzy"},
+		// Too many edits, words too short to take an edit, and categories without the mode
+		{"interesting", "unknown", ""},
+		{"na thanks", "unknown", ""},
+		{"call me bak", "unknown", ""},
+		// Literal matches come first: spelled right, and in the same priority level
+		{"interested", "interested", "exact"},
+		{"i am interested", "interested", "phrase"},
+		{"intrested but busy", "busy", "phrase"},
+		// A fuzzy hit still wins over literal hits of lower priority levels
+		{"intrested call me back", "interested", "fuzzy"},
+	})
+}
@@ -6,6 +6,7 @@ toolchain go1.24.10
 
 require (
 	github.com/fsnotify/fsnotify v1.9.0
+	github.com/gorilla/websocket v1.5.3
 	github.com/labstack/echo/v4 v4.13.4
 	golang.org/x/text v0.31.0
 )
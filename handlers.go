@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
@@ -34,15 +36,18 @@ func handleCacheInfo(c echo.Context) error {
 		}
 
 		campaigns = append(campaigns, map[string]interface{}{
-			"campaign":  campaign,
-			"loaded_at": matcher.loadedAt,
-			"file_path": matcher.filePath,
-			"stages":    stageInfo,
+			"campaign":          campaign,
+			"loaded_at":         matcher.loadedAt,
+			"version":           matcher.version,
+			"file_path":         matcher.filePath,
+			"stages":            stageInfo,
+			"last_reload_error": campaignCache.reloadErrors[campaign],
 		})
 	}
 
 	info["cached_campaigns"] = len(campaignCache.matchers)
 	info["campaigns"] = campaigns
+	info["reload_errors"] = campaignCache.reloadErrors
 	info["timestamp"] = time.Now()
 
 	return c.JSON(http.StatusOK, info)
@@ -51,28 +56,54 @@ func handleCacheInfo(c echo.Context) error {
 func handleReloadCampaign(c echo.Context) error {
 	campaign := c.Param("campaign")
 
-	campaignCache.Lock()
-	delete(campaignCache.matchers, campaign)
-	delete(campaignCache.fileModTimes, campaign)
-	campaignCache.Unlock()
+	if _, err := campaignCache.reloadCampaign(campaign, true); err != nil {
+		campaignCache.RLock()
+		_, kept := campaignCache.matchers[campaign]
+		campaignCache.RUnlock()
+
+		message := fmt.Sprintf("Campaign '%s' reload failed", campaign)
+		if kept {
+			message += ", previous version is still serving"
+		}
+		return c.JSON(http.StatusUnprocessableEntity, ReloadResponse{
+			Message:    message,
+			Campaign:   campaign,
+			ReloadedAt: time.Now(),
+			Errors:     map[string]string{campaign: err.Error()},
+		})
+	}
 
 	return c.JSON(http.StatusOK, ReloadResponse{
-		Message:    fmt.Sprintf("Campaign '%s' cache cleared and will reload on next request", campaign),
+		Message:    fmt.Sprintf("Campaign '%s' reloaded", campaign),
 		Campaign:   campaign,
 		ReloadedAt: time.Now(),
 	})
 }
 
 func handleReloadAll(c echo.Context) error {
-	campaignCache.Lock()
-	count := len(campaignCache.matchers)
-	campaignCache.matchers = make(map[string]*KeywordMatcher)
-	campaignCache.fileModTimes = make(map[string]time.Time)
-	campaignCache.Unlock()
+	campaignCache.RLock()
+	campaigns := make([]string, 0, len(campaignCache.matchers))
+	for campaign := range campaignCache.matchers {
+		campaigns = append(campaigns, campaign)
+	}
+	campaignCache.RUnlock()
 
-	return c.JSON(http.StatusOK, ReloadResponse{
-		Message:    fmt.Sprintf("All %d campaign caches cleared and will reload on next request", count),
+	errors := make(map[string]string)
+	for _, campaign := range campaigns {
+		if _, err := campaignCache.reloadCampaign(campaign, true); err != nil {
+			errors[campaign] = err.Error()
+		}
+	}
+
+	status := http.StatusOK
+	if len(errors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+
+	return c.JSON(status, ReloadResponse{
+		Message:    fmt.Sprintf("Reloaded %d of %d campaigns, failed ones keep their previous version", len(campaigns)-len(errors), len(campaigns)),
 		ReloadedAt: time.Now(),
+		Errors:     errors,
 	})
 }
 
@@ -84,18 +115,12 @@ func handleMatch(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
 	}
 
-	// Validate required fields
-	if req.Campaign == "" || req.SpeechText == "" || req.Stage == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "campaign, speech_text, and stage are required",
-		})
+	if msg := validateMatchRequest(&req); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
 	}
 
-	// Validate stage format (must be s1, s2, s3, etc.)
-	if !strings.HasPrefix(req.Stage, "s") {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid stage format. Must be s1, s2, s3, etc.",
-		})
+	if !authorizeCampaign(c, req.Campaign) {
+		return forbiddenCampaign(c, req.Campaign)
 	}
 
 	// Get or load matcher for campaign
@@ -106,12 +131,206 @@ func handleMatch(c echo.Context) error {
 		})
 	}
 
+	// N-best hypotheses are matched one by one and combined
+	if len(req.Alternatives) > 0 {
+		decision := matchAlternativesObserved(matcher, &req)
+		return c.JSON(http.StatusOK, MatchResponse{
+			Result:       decision.returnValue(),
+			Stage:        req.Stage,
+			Campaign:     req.Campaign,
+			Score:        scoreOf(decision.result),
+			Policy:       req.Policy,
+			Alternative:  decision.alternativeIndex(),
+			Alternatives: decision.results,
+		})
+	}
+
 	// Process using generic stage processor
-	result := matcher.ProcessStage(req.SpeechText, req.Stage)
+	result := processStageObserved(matcher, req.Campaign, req.SpeechText, req.Stage)
 
 	return c.JSON(http.StatusOK, MatchResponse{
-		Result:   result,
+		Result:   returnValueOf(result),
 		Stage:    req.Stage,
 		Campaign: req.Campaign,
+		Score:    scoreOf(result),
 	})
 }
+
+func handleMatchExplain(c echo.Context) error {
+	var req MatchRequest
+
+	// Bind request (works for both POST JSON and GET query params)
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	if msg := validateMatchRequest(&req); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	if !authorizeCampaign(c, req.Campaign) {
+		return forbiddenCampaign(c, req.Campaign)
+	}
+
+	// Get or load matcher for campaign
+	matcher, err := getMatcher(req.Campaign)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": fmt.Sprintf("Campaign not found: %s", req.Campaign),
+		})
+	}
+
+	explanation := matcher.ExplainStage(req.SpeechText, req.Stage)
+	explanation.Campaign = req.Campaign
+
+	return c.JSON(http.StatusOK, explanation)
+}
+
+// handleMatchLabels returns every category that matched the utterance (see
+// LabelStage); the primary result is recorded like a /match request
+func handleMatchLabels(c echo.Context) error {
+	var req MatchRequest
+
+	// Bind request (works for both POST JSON and GET query params)
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	if msg := validateMatchRequest(&req); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	if !authorizeCampaign(c, req.Campaign) {
+		return forbiddenCampaign(c, req.Campaign)
+	}
+
+	// Get or load matcher for campaign
+	matcher, err := getMatcher(req.Campaign)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": fmt.Sprintf("Campaign not found: %s", req.Campaign),
+		})
+	}
+
+	start := time.Now()
+	labels := matcher.LabelStage(req.SpeechText, req.Stage)
+	labels.Campaign = req.Campaign
+	primary := labels.primary()
+	observeMatch(matcher, req.Campaign, req.Stage, req.SpeechText, primary, time.Since(start))
+	captureMatch(matcher, req.Campaign, req.SpeechText, req.Stage, primary)
+
+	return c.JSON(http.StatusOK, labels)
+}
+
+// handleMatchBatch matches many requests in one call
+// Accepts a JSON array of MatchRequest items, or NDJSON (one MatchRequest per line)
+// when the Content-Type is application/x-ndjson. NDJSON input, or an Accept header
+// of application/x-ndjson, streams the results back one JSON object per line.
+// Results are always returned in input order.
+func handleMatchBatch(c echo.Context) error {
+	req := c.Request()
+	ndjsonIn := strings.HasPrefix(req.Header.Get(echo.HeaderContentType), "application/x-ndjson")
+	ndjsonOut := ndjsonIn || strings.Contains(req.Header.Get("Accept"), "application/x-ndjson")
+
+	var next func() (batchItem, bool, error)
+	if ndjsonIn {
+		// One request per line; a malformed line becomes a per-item error
+		scanner := bufio.NewScanner(req.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		next = func() (batchItem, bool, error) {
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" {
+					continue
+				}
+				var item batchItem
+				if err := json.Unmarshal([]byte(line), &item.req); err != nil {
+					item.err = "Invalid request"
+				}
+				return item, true, nil
+			}
+			return batchItem{}, false, scanner.Err()
+		}
+	} else {
+		// JSON array, decoded element by element; an element that is not a valid
+		// match request becomes a per-item error, malformed JSON ends the batch
+		decoder := json.NewDecoder(req.Body)
+		if tok, err := decoder.Token(); err != nil || tok != json.Delim('[') {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Request body must be a JSON array of match requests"})
+		}
+		next = func() (batchItem, bool, error) {
+			if !decoder.More() {
+				return batchItem{}, false, nil
+			}
+			var element json.RawMessage
+			if err := decoder.Decode(&element); err != nil {
+				return batchItem{}, false, err
+			}
+			var item batchItem
+			if err := json.Unmarshal(element, &item.req); err != nil {
+				item.err = "Invalid request"
+			}
+			return item, true, nil
+		}
+	}
+
+	// Items for campaigns outside the key's allowlist fail individually
+	read := next
+	next = func() (batchItem, bool, error) {
+		item, ok, err := read()
+		if ok && item.err == "" && !authorizeCampaign(c, item.req.Campaign) {
+			item.err = fmt.Sprintf("Not allowed for campaign: %s", item.req.Campaign)
+		}
+		return item, ok, err
+	}
+
+	if ndjsonOut {
+		res := c.Response()
+		res.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+		res.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(res)
+		err := processBatch(next, func(result BatchMatchResult) error {
+			if err := encoder.Encode(result); err != nil {
+				return err
+			}
+			res.Flush()
+			return nil
+		}, batchWorkers)
+		if err != nil {
+			// Headers are already sent; report the failure as a final line
+			encoder.Encode(map[string]string{"error": err.Error()})
+		}
+		return nil
+	}
+
+	results := make([]BatchMatchResult, 0)
+	err := processBatch(next, func(result BatchMatchResult) error {
+		results = append(results, result)
+		return nil
+	}, batchWorkers)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid batch: %v", err)})
+	}
+
+	return c.JSON(http.StatusOK, BatchMatchResponse{Results: results})
+}
+
+// validateMatchRequest checks the required fields of a match request
+// Returns an error message, or "" if the request is valid
+func validateMatchRequest(req *MatchRequest) string {
+	// Validate required fields
+	if req.Campaign == "" || (req.SpeechText == "" && len(req.Alternatives) == 0) || req.Stage == "" {
+		return "campaign, speech_text, and stage are required"
+	}
+
+	// Validate stage format (must be s1, s2, s3, etc.)
+	if !strings.HasPrefix(req.Stage, "s") {
+		return "Invalid stage format. Must be s1, s2, s3, etc."
+	}
+
+	if len(req.Alternatives) > 0 {
+		return validateAlternatives(req)
+	}
+
+	return ""
+}
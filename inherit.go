@@ -0,0 +1,380 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Reserved top-level keys for campaign inheritance
+// Example:
+//
+//	{
+//	  "extends": "fe_base",
+//	  "include": ["_lib/answer_machine.json", "_lib/do_not_call.json"],
+//	  "remove": {
+//	    "categories": ["honeypot_hardcoded_s2"],
+//	    "keywords": {"answerMachine_p1_s1": ["leave a message"]}
+//	  },
+//	  "append": {"answerMachine_p1_s1": ["after the tone"]},
+//	  "interested_p6_s1": ["all good"]
+//	}
+//
+// The base campaign (extends) is merged first, then every include in order, then
+// the file's own categories and settings; a category defined again replaces the
+// earlier one as a whole, and settings are merged key by key. remove and append
+// are applied last. Paths are relative to the directory of the file, and a base
+// campaign is named like any campaign. Every file in the chain must use the same
+// format version; a file without "version" takes that of its base campaign.
+const (
+	extendsKey = "extends"
+	includeKey = "include"
+	removeKey  = "remove"
+	appendKey  = "append"
+)
+
+// maxInheritanceDepth bounds extends/include chains
+const maxInheritanceDepth = 16
+
+// resolvedCampaign is a campaign file with its base campaign and libraries merged in
+type resolvedCampaign struct {
+	raw          FlexibleKeywordSets
+	order        KeyOrder
+	dependencies map[string]time.Time // Merged files other than the campaign file -> modification time when read
+	files        []string             // Merged files other than the campaign file, in merge order
+	warnings     []loadWarning
+	digest       hash.Hash // Content of every file, in merge order
+}
+
+// isInheritanceKey reports whether key is one of the inheritance keys
+func isInheritanceKey(key string) bool {
+	return key == extendsKey || key == includeKey || key == removeKey || key == appendKey
+}
+
+// resolveCampaign merges the base campaign and libraries of a campaign file
+// Files without inheritance keys are returned as parsed.
+func resolveCampaign(filePath string, data []byte) (*resolvedCampaign, error) {
+	rc := &resolvedCampaign{
+		dependencies: make(map[string]time.Time),
+		digest:       sha256.New(),
+	}
+	raw, order, err := rc.resolve(filepath.Clean(filePath), data, nil)
+	if err != nil {
+		return nil, err
+	}
+	rc.raw, rc.order = raw, order
+	return rc, nil
+}
+
+// version identifies the content of the campaign file and all of its dependencies
+func (rc *resolvedCampaign) version() string {
+	return hex.EncodeToString(rc.digest.Sum(nil)[:6])
+}
+
+// resolve parses one file of the chain; stack holds the files being resolved
+func (rc *resolvedCampaign) resolve(filePath string, data []byte, stack []string) (FlexibleKeywordSets, KeyOrder, error) {
+	rc.digest.Write(data)
+
+	raw, order, err := parseCampaignData(data)
+	if err != nil {
+		if len(stack) > 0 {
+			return nil, nil, fmt.Errorf("%s: %w", filePath, err)
+		}
+		return nil, nil, err
+	}
+
+	inherits := false
+	for key := range raw {
+		if isInheritanceKey(key) {
+			inherits = true
+		}
+	}
+	if !inherits {
+		return raw, order, nil
+	}
+
+	stack = append(stack, filePath)
+	if len(stack) > maxInheritanceDepth {
+		return nil, nil, fmt.Errorf("%s: inheritance chain is deeper than %d files", filePath, maxInheritanceDepth)
+	}
+
+	// Base campaign first, then libraries in order
+	dir := filepath.Dir(filePath)
+	var parents []string
+	if value, ok := raw[extendsKey]; ok {
+		name, isString := value.(string)
+		if !isString || name == "" {
+			return nil, nil, fmt.Errorf("%s: %q must be a campaign name", filePath, extendsKey)
+		}
+		if !strings.HasSuffix(name, ".json") {
+			name += ".json"
+		}
+		parents = append(parents, filepath.Join(dir, name))
+	}
+	if value, ok := raw[includeKey]; ok {
+		list, isList := value.([]interface{})
+		if !isList {
+			return nil, nil, fmt.Errorf("%s: %q must be a list of files", filePath, includeKey)
+		}
+		for _, item := range list {
+			path, isString := item.(string)
+			if !isString || path == "" {
+				return nil, nil, fmt.Errorf("%s: %q must be a list of files", filePath, includeKey)
+			}
+			parents = append(parents, filepath.Join(dir, path))
+		}
+	}
+
+	merged := make(FlexibleKeywordSets)
+	mergedOrder := make(KeyOrder)
+	for _, parent := range parents {
+		for _, seen := range stack {
+			if seen == parent {
+				return nil, nil, fmt.Errorf("%s: inheritance cycle through %s", filePath, parent)
+			}
+		}
+
+		info, err := os.Stat(parent)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filePath, err)
+		}
+		parentData, err := os.ReadFile(parent)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filePath, err)
+		}
+		rc.dependencies[parent] = info.ModTime()
+
+		parentRaw, parentOrder, err := rc.resolve(parent, parentData, stack)
+		if err != nil {
+			return nil, nil, err
+		}
+		rc.files = append(rc.files, parent)
+		if err := mergeCampaign(merged, mergedOrder, parentRaw, parentOrder); err != nil {
+			return nil, nil, fmt.Errorf("%s: %s: %w", filePath, parent, err)
+		}
+	}
+
+	own := make(FlexibleKeywordSets, len(raw))
+	for key, value := range raw {
+		if !isInheritanceKey(key) {
+			own[key] = value
+		}
+	}
+	if err := mergeCampaign(merged, mergedOrder, own, order); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	// Removals and additions of this file apply to the merged result; only the
+	// campaign file's own paths are reported (libraries are linted on their own)
+	warnf := func(path, format string, args ...interface{}) {
+		if len(stack) == 1 {
+			rc.warnings = append(rc.warnings, loadWarning{path: path, message: fmt.Sprintf(format, args...)})
+		}
+	}
+	if value, ok := raw[removeKey]; ok {
+		applyRemovals(merged, value, warnf)
+	}
+	if value, ok := raw[appendKey]; ok {
+		applyAppends(merged, value, warnf)
+	}
+
+	return merged, mergedOrder, nil
+}
+
+// mergeCampaign merges src into dst: categories of src replace those of dst,
+// settings are merged key by key. A src without "version" takes the version of
+// dst, so a file with only extends and append can extend a version 2 base.
+func mergeCampaign(dst FlexibleKeywordSets, dstOrder KeyOrder, src FlexibleKeywordSets, srcOrder KeyOrder) error {
+	if _, explicit := src[versionKey]; explicit && len(dst) > 0 {
+		dstVersion, _ := campaignFormatVersion(dst)
+		srcVersion, _ := campaignFormatVersion(src)
+		if dstVersion != srcVersion {
+			return fmt.Errorf("cannot merge a version %d campaign into a version %d campaign", srcVersion, dstVersion)
+		}
+	}
+
+	for _, key := range orderedKeys(src, srcOrder[""]) {
+		addOrderKey(dstOrder, "", key)
+
+		srcObject, srcIsObject := src[key].(map[string]interface{})
+		dstObject, dstIsObject := dst[key].(map[string]interface{})
+		if (key == settingsKey || key == categoriesKey) && srcIsObject && dstIsObject {
+			// Merge member by member
+			for _, member := range orderedKeys(srcObject, srcOrder[key]) {
+				dstObject[member] = srcObject[member]
+				addOrderKey(dstOrder, key, member)
+				copyOrder(dstOrder, srcOrder, jsonPath(key, member))
+			}
+			continue
+		}
+
+		dst[key] = src[key]
+		copyOrder(dstOrder, srcOrder, key)
+	}
+	return nil
+}
+
+// addOrderKey appends key to the order of the object at path unless present
+func addOrderKey(order KeyOrder, path, key string) {
+	for _, existing := range order[path] {
+		if existing == key {
+			return
+		}
+	}
+	order[path] = append(order[path], key)
+}
+
+// copyOrder replaces the key order of everything at or below prefix with that of src
+func copyOrder(dst, src KeyOrder, prefix string) {
+	for path := range dst {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			delete(dst, path)
+		}
+	}
+	for path, keys := range src {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			dst[path] = append([]string(nil), keys...)
+		}
+	}
+}
+
+// keywordList returns the keyword list of a merged category and a function to
+// store an edited list, for both file formats
+func keywordList(merged FlexibleKeywordSets, categoryKey string) ([]interface{}, func([]interface{}), bool) {
+	container := map[string]interface{}(merged)
+	member := categoryKey
+	if version, _ := campaignFormatVersion(merged); version == 2 {
+		categories, _ := merged[categoriesKey].(map[string]interface{})
+		category, ok := categories[categoryKey].(map[string]interface{})
+		if !ok {
+			return nil, nil, false
+		}
+		container, member = category, "keywords"
+	} else if _, ok := merged[categoryKey]; !ok || categoryKey == settingsKey || categoryKey == versionKey {
+		return nil, nil, false
+	}
+
+	value, exists := container[member]
+	list, isList := value.([]interface{})
+	if exists && value != nil && !isList {
+		return nil, nil, false
+	}
+	return list, func(list []interface{}) { container[member] = list }, true
+}
+
+// applyRemovals applies a "remove" object to a merged campaign
+func applyRemovals(merged FlexibleKeywordSets, value interface{}, warnf func(path, format string, args ...interface{})) {
+	removals, ok := value.(map[string]interface{})
+	if !ok {
+		warnf(removeKey, "%q must be an object", removeKey)
+		return
+	}
+
+	if list, ok := removals["categories"].([]interface{}); ok {
+		categories := map[string]interface{}(merged)
+		if version, _ := campaignFormatVersion(merged); version == 2 {
+			categories, _ = merged[categoriesKey].(map[string]interface{})
+		}
+		for i, item := range list {
+			categoryKey, _ := item.(string)
+			if _, exists := categories[categoryKey]; !exists || categoryKey == settingsKey || categoryKey == versionKey {
+				warnf(jsonPath(removeKey, "categories", fmt.Sprint(i)), "remove refers to unknown category: %v", item)
+				continue
+			}
+			delete(categories, categoryKey)
+		}
+	}
+
+	if keywords, ok := removals["keywords"].(map[string]interface{}); ok {
+		for _, categoryKey := range orderedKeys(keywords, nil) {
+			value := keywords[categoryKey]
+			path := jsonPath(removeKey, "keywords", categoryKey)
+			list, store, ok := keywordList(merged, categoryKey)
+			if !ok {
+				warnf(path, "remove refers to unknown category: %s", categoryKey)
+				continue
+			}
+			remove, _ := value.([]interface{})
+			kept := make([]interface{}, 0, len(list))
+			removed := make(map[string]bool)
+			for _, kw := range list {
+				drop := false
+				for _, target := range remove {
+					if s, ok := kw.(string); ok && strings.EqualFold(strings.TrimSpace(s), fmt.Sprint(target)) {
+						drop = true
+						removed[fmt.Sprint(target)] = true
+					}
+				}
+				if !drop {
+					kept = append(kept, kw)
+				}
+			}
+			for i, target := range remove {
+				if !removed[fmt.Sprint(target)] {
+					warnf(jsonPath(path, fmt.Sprint(i)), "keyword %q is not in inherited category %s", target, categoryKey)
+				}
+			}
+			store(kept)
+		}
+	}
+}
+
+// applyAppends applies an "append" object to a merged campaign
+func applyAppends(merged FlexibleKeywordSets, value interface{}, warnf func(path, format string, args ...interface{})) {
+	appends, ok := value.(map[string]interface{})
+	if !ok {
+		warnf(appendKey, "%q must be an object", appendKey)
+		return
+	}
+
+	for _, categoryKey := range orderedKeys(appends, nil) {
+		value := appends[categoryKey]
+		list, store, ok := keywordList(merged, categoryKey)
+		if !ok {
+			warnf(jsonPath(appendKey, categoryKey), "append refers to unknown category: %s", categoryKey)
+			continue
+		}
+		additions, _ := value.([]interface{})
+		store(append(append([]interface{}{}, list...), additions...))
+	}
+}
+
+// newKeywordMatcherFromData builds the matcher of a campaign file, resolving its
+// base campaign and libraries
+func newKeywordMatcherFromData(filePath string, data []byte) (*KeywordMatcher, error) {
+	rc, err := resolveCampaign(filePath, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse campaign keywords: %w", err)
+	}
+
+	km := NewKeywordMatcher(rc.raw, rc.order, filePath)
+	km.version = rc.version()
+	km.dependencies = rc.dependencies
+	for _, w := range rc.warnings {
+		km.warnf(w.path, "%s", w.message)
+	}
+	return km, nil
+}
+
+// dependenciesModified reports whether a base campaign or library of the
+// matcher changed (or disappeared) since it was loaded
+func (km *KeywordMatcher) dependenciesModified() bool {
+	for path, modTime := range km.dependencies {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().After(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// dependsOn reports whether the matcher merged in the file at path
+func (km *KeywordMatcher) dependsOn(path string) bool {
+	_, ok := km.dependencies[filepath.Clean(path)]
+	return ok
+}
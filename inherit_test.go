@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeTestFile writes a campaign or library file, creating its directory
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const testV2Base = `{
+	"version": 2,
+	"categories": {
+		"interested_s1": {"stage": "s1", "priority": 1, "keywords": ["i am fine"]}
+	}
+}`
+
+// A child with only extends and append has no "version" and takes its base's
+func TestExtendsVersion2WithoutVersion(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "base.json"), testV2Base)
+	writeTestFile(t, filepath.Join(dir, "child.json"), `{"extends": "base", "append": {"interested_s1": ["all good"]}}`)
+	writeTestFile(t, filepath.Join(dir, "flat.json"), `{"version": 1, "extends": "base"}`)
+
+	km, err := loadKeywordMatcher(filepath.Join(dir, "child.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"i am fine", "all good"} {
+		if got := km.ProcessStage(text, "s1"); got != "interested" {
+			t.Errorf("ProcessStage(%q) = %q, want interested", text, got)
+		}
+	}
+
+	if _, err := loadKeywordMatcher(filepath.Join(dir, "flat.json")); err == nil {
+		t.Errorf("a version 1 file extending a version 2 base should fail")
+	}
+}
+
+// Libraries in directories created after startup are watched
+func TestWatchNewLibraryDirectory(t *testing.T) {
+	dir := t.TempDir()
+	cc, err := NewCampaignCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	go cc.WatchFiles()
+
+	libDir := filepath.Join(dir, "_lib")
+	if err := os.Mkdir(libDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the new directory to be watched", func() bool {
+		return slices.Contains(cc.watcher.WatchList(), libDir)
+	})
+
+	writeTestFile(t, filepath.Join(libDir, "am.json"), `{"answerMachine_p1_s1": ["leave a message"]}`)
+	writeTestFile(t, filepath.Join(dir, "campaign.json"), `{"include": ["_lib/am.json"], "busy_p2_s1": ["busy"]}`)
+	if _, err := cc.reloadCampaign("campaign", true); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	writeTestFile(t, filepath.Join(libDir, "am.json"), `{"answerMachine_p1_s1": ["leave a message", "after the tone"]}`)
+	waitFor(t, "the campaign to reload", func() bool {
+		cc.RLock()
+		defer cc.RUnlock()
+		return cc.matchers["campaign"].ProcessStage("after the tone", "s1") == "answermachine"
+	})
+}
+
+// waitFor polls done for up to five seconds
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if done() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// KeywordStatsResponse reports how often each keyword of a campaign won a match,
+// and how often it was found but lost to another keyword (shadowed)
+// Counters start when the campaign is first loaded or when they are reset; file
+// reloads and admin edits keep the counts of the keywords they do not remove.
+type KeywordStatsResponse struct {
+	Campaign   string                 `json:"campaign"`
+	Version    string                 `json:"version,omitempty"`
+	Since      time.Time              `json:"since"`
+	Matches    uint64                 `json:"matches"` // Matches won by any keyword
+	Keywords   int                    `json:"keywords"`
+	Dead       int                    `json:"dead"`      // Keywords never found, neither winning nor shadowed
+	NeverWon   int                    `json:"never_won"` // Keywords only ever shadowed by another keyword
+	Categories []CategoryKeywordStats `json:"categories"`
+}
+
+// CategoryKeywordStats lists the keyword counters of one category, most hits first
+type CategoryKeywordStats struct {
+	Stage       string        `json:"stage"`
+	CategoryKey string        `json:"category_key"`
+	Matches     uint64        `json:"matches"`
+	Keywords    []KeywordStat `json:"keywords"`
+}
+
+type KeywordStat struct {
+	Keyword  string `json:"keyword"`
+	Hits     uint64 `json:"hits"`
+	Shadowed uint64 `json:"shadowed"`           // Matches the keyword was found in but another keyword won
+	Rerouted bool   `json:"rerouted,omitempty"` // Negated copy of a keyword of another category
+}
+
+// keywordStatsOptions selects which keywords a stats report lists
+type keywordStatsOptions struct {
+	deadOnly bool // Only keywords never found
+	top      int  // At most this many keywords per category (0: all)
+}
+
+// countMatch counts a production match in the keyword stats: a win for the
+// keyword of result, and a shadowed hit for every other keyword found in the text.
+// Shadowed hits keep a keyword that is always outranked from looking dead.
+func (sc *StageCategories) countMatch(result *matchResult, hits []keywordHit) {
+	for _, hit := range hits {
+		if result == nil || hit.pattern != result.pattern {
+			sc.keywordShadowed[hit.pattern].Add(1)
+		}
+	}
+	if result != nil {
+		sc.keywordHits[result.pattern].Add(1)
+	}
+}
+
+// keywordStats builds the hit report of every category of the campaign
+func (km *KeywordMatcher) keywordStats(opts keywordStatsOptions) *KeywordStatsResponse {
+	stats := &KeywordStatsResponse{
+		Version:    km.version,
+		Since:      km.statsStart(),
+		Categories: make([]CategoryKeywordStats, 0),
+	}
+
+	stages := make([]string, 0, len(km.stageMap))
+	for stage := range km.stageMap {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+
+	for _, stage := range stages {
+		sc := km.stageMap[stage]
+		for g := range sc.groups {
+			group := &sc.groups[g]
+			for c := range group.categories {
+				catEntry := &group.categories[c]
+				category := CategoryKeywordStats{
+					Stage:       stage,
+					CategoryKey: catEntry.Info.Key,
+					Keywords:    make([]KeywordStat, 0),
+				}
+
+				for pattern := group.firstPattern; pattern < group.endPattern; pattern++ {
+					ref := sc.patterns[pattern]
+					if ref.category != c || ref.exclude {
+						continue
+					}
+					entry := &catEntry.Keywords[ref.keyword]
+					hits := sc.keywordHits[pattern].Load()
+					shadowed := sc.keywordShadowed[pattern].Load()
+
+					category.Matches += hits
+					stats.Keywords++
+					switch {
+					case hits == 0 && shadowed == 0:
+						stats.Dead++
+					case hits == 0:
+						stats.NeverWon++
+					}
+					if opts.deadOnly && hits+shadowed > 0 {
+						continue
+					}
+					category.Keywords = append(category.Keywords, KeywordStat{
+						Keyword:  entry.raw,
+						Hits:     hits,
+						Shadowed: shadowed,
+						Rerouted: entry.negatedOnly,
+					})
+				}
+
+				sort.SliceStable(category.Keywords, func(i, j int) bool {
+					a, b := &category.Keywords[i], &category.Keywords[j]
+					if a.Hits != b.Hits {
+						return a.Hits > b.Hits
+					}
+					return a.Shadowed > b.Shadowed
+				})
+				if opts.top > 0 && len(category.Keywords) > opts.top {
+					category.Keywords = category.Keywords[:opts.top]
+				}
+
+				stats.Matches += category.Matches
+				stats.Categories = append(stats.Categories, category)
+			}
+		}
+	}
+
+	return stats
+}
+
+// resetKeywordStats sets every keyword counter back to zero
+func (km *KeywordMatcher) resetKeywordStats() {
+	km.statsSince.Store(time.Now().UnixNano())
+	for _, sc := range km.stageMap {
+		for i := range sc.keywordHits {
+			sc.keywordHits[i].Store(0)
+			sc.keywordShadowed[i].Store(0)
+		}
+	}
+}
+
+// statsStart returns when the keyword counters started
+func (km *KeywordMatcher) statsStart() time.Time {
+	if since := km.statsSince.Load(); since != 0 {
+		return time.Unix(0, since)
+	}
+	return km.loadedAt
+}
+
+// keywordCounterKey identifies a keyword counter across versions of a campaign
+type keywordCounterKey struct {
+	categoryKey string
+	keyword     string
+	rerouted    bool
+}
+
+// carryKeywordStats adds the counters of previous, the version of the campaign
+// km replaces, to those of km. Counters are matched by category key and
+// keyword; those of keywords that no longer exist are dropped.
+func (km *KeywordMatcher) carryKeywordStats(previous *KeywordMatcher) {
+	counts := make(map[keywordCounterKey][2]uint64)
+	previous.eachKeywordCounter(func(key keywordCounterKey, hits, shadowed *atomic.Uint64) {
+		n := counts[key]
+		counts[key] = [2]uint64{n[0] + hits.Load(), n[1] + shadowed.Load()}
+	})
+	km.eachKeywordCounter(func(key keywordCounterKey, hits, shadowed *atomic.Uint64) {
+		n := counts[key]
+		hits.Add(n[0])
+		shadowed.Add(n[1])
+		// A keyword listed twice in a category only keeps its count once
+		delete(counts, key)
+	})
+	km.statsSince.Store(previous.statsStart().UnixNano())
+}
+
+// eachKeywordCounter calls fn with the win and shadowed counters of every keyword
+func (km *KeywordMatcher) eachKeywordCounter(fn func(key keywordCounterKey, hits, shadowed *atomic.Uint64)) {
+	for _, sc := range km.stageMap {
+		for pattern, ref := range sc.patterns {
+			if ref.exclude {
+				continue
+			}
+			entry := sc.entryOf(keywordHit{pattern: pattern})
+			key := keywordCounterKey{
+				categoryKey: sc.categoryOf(keywordHit{pattern: pattern}).Info.Key,
+				keyword:     entry.raw,
+				rerouted:    entry.negatedOnly,
+			}
+			fn(key, &sc.keywordHits[pattern], &sc.keywordShadowed[pattern])
+		}
+	}
+}
+
+// handleKeywordStats reports keyword hit counters of a cached campaign
+// Query parameters: dead=true lists only keywords that were never found,
+// top=N lists at most N keywords per category.
+func handleKeywordStats(c echo.Context) error {
+	campaign := c.Param("campaign")
+
+	matcher, err := getMatcher(campaign)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": fmt.Sprintf("Campaign not found: %s", campaign),
+		})
+	}
+
+	var opts keywordStatsOptions
+	opts.deadOnly, _ = strconv.ParseBool(c.QueryParam("dead"))
+	if top := c.QueryParam("top"); top != "" {
+		n, err := strconv.Atoi(top)
+		if err != nil || n < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "top must be a non-negative integer"})
+		}
+		opts.top = n
+	}
+
+	stats := matcher.keywordStats(opts)
+	stats.Campaign = campaign
+	return c.JSON(http.StatusOK, stats)
+}
+
+func handleResetKeywordStats(c echo.Context) error {
+	campaign := c.Param("campaign")
+
+	matcher, err := getMatcher(campaign)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": fmt.Sprintf("Campaign not found: %s", campaign),
+		})
+	}
+
+	matcher.resetKeywordStats()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":  fmt.Sprintf("Keyword stats of campaign '%s' reset", campaign),
+		"campaign": campaign,
+		"reset_at": time.Now(),
+	})
+}
+
+// runKeywordStats implements the "keyword-stats" subcommand.
+// It replays a capture file (see CaptureRecord) or a labeled dataset (see
+// runEvaluate) through ProcessStage and prints the same report as
+// /admin/keyword-stats for every campaign in it.
+//
+//	./main keyword-stats -dead requests.jsonl
+//	./main keyword-stats -top 5 -json golden.csv
+func runKeywordStats(args []string) int {
+	fs := flag.NewFlagSet("keyword-stats", flag.ContinueOnError)
+	keywordsDir := fs.String("keywords", "keywords", "keywords directory")
+	deadOnly := fs.Bool("dead", false, "list only keywords that were never found")
+	top := fs.Int("top", 0, "list at most this many keywords per category (0 = all)")
+	jsonOut := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "keyword-stats: no transcript file given")
+		return 2
+	}
+
+	// Loading logs every warning; keyword-stats only reports counters
+	log.SetOutput(io.Discard)
+
+	matchers := make(map[string]*KeywordMatcher)
+	failed := make(map[string]bool)
+	var campaigns []string
+	for _, file := range fs.Args() {
+		samples, err := readEvaluationSamples(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "keyword-stats: %s: %v\n", file, err)
+			return 2
+		}
+		for _, sample := range samples {
+			matcher, ok := matchers[sample.Campaign]
+			if !ok {
+				if failed[sample.Campaign] {
+					continue
+				}
+				matcher, err = loadKeywordMatcher(filepath.Join(*keywordsDir, sample.Campaign+".json"))
+				if err != nil {
+					// Reported once; the campaign's samples are skipped
+					fmt.Fprintf(os.Stderr, "keyword-stats: skipping campaign %q: %v\n", sample.Campaign, err)
+					failed[sample.Campaign] = true
+					continue
+				}
+				matchers[sample.Campaign] = matcher
+				campaigns = append(campaigns, sample.Campaign)
+			}
+			matcher.ProcessStage(sample.SpeechText, sample.Stage)
+		}
+	}
+	sort.Strings(campaigns)
+
+	reports := make([]*KeywordStatsResponse, 0, len(campaigns))
+	for _, campaign := range campaigns {
+		stats := matchers[campaign].keywordStats(keywordStatsOptions{deadOnly: *deadOnly, top: *top})
+		stats.Campaign = campaign
+		reports = append(reports, stats)
+	}
+
+	if *jsonOut {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			fmt.Fprintf(os.Stderr, "keyword-stats: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	for _, stats := range reports {
+		fmt.Printf("== %s: %d matches, %d of %d keywords never found, %d only shadowed\n",
+			stats.Campaign, stats.Matches, stats.Dead, stats.Keywords, stats.NeverWon)
+		for _, category := range stats.Categories {
+			if len(category.Keywords) == 0 {
+				continue
+			}
+			fmt.Printf("%s %s (%d matches)\n", category.Stage, category.CategoryKey, category.Matches)
+			for _, kw := range category.Keywords {
+				fmt.Printf("  %8d won %8d shadowed  %s\n", kw.Hits, kw.Shadowed, kw.Keyword)
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Reloads and edits keep the counters of the keywords they do not remove
+func TestKeywordStatsSurviveReload(t *testing.T) {
+	dir := t.TempDir()
+	cc, err := NewCampaignCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	path := filepath.Join(dir, "campaign.json")
+	writeTestFile(t, path, `{"busy_p1_s1": ["busy", "driving"], "later_p2_s1": ["call me later"]}`)
+	km, err := cc.reloadCampaign("campaign", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"busy", "i am busy", "driving", "call me later"} {
+		km.ProcessStage(text, "s1")
+	}
+	since := km.keywordStats(keywordStatsOptions{}).Since
+
+	// "driving" is removed, "at work" is new and later_p2_s1 moves to p3
+	writeTestFile(t, path, `{"busy_p1_s1": ["at work", "busy"], "later_p3_s1": ["call me later"]}`)
+	reloaded, err := cc.reloadCampaign("campaign", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := reloaded.keywordStats(keywordStatsOptions{})
+	if !stats.Since.Equal(since) {
+		t.Errorf("Since = %v, want %v from before the reload", stats.Since, since)
+	}
+	hits := make(map[string]uint64)
+	for _, category := range stats.Categories {
+		for _, kw := range category.Keywords {
+			hits[category.CategoryKey+"/"+kw.Keyword] = kw.Hits
+		}
+	}
+	want := map[string]uint64{"busy_p1_s1/busy": 2, "busy_p1_s1/at work": 0, "later_p3_s1/call me later": 0}
+	for key, n := range want {
+		if hits[key] != n {
+			t.Errorf("hits of %s = %d, want %d", key, hits[key], n)
+		}
+	}
+	if stats.Matches != 2 {
+		t.Errorf("Matches = %d, want 2", stats.Matches)
+	}
+}
+
+// Only production matches count; explain and labels leave the counters alone
+func TestKeywordStatsCountProductionMatchesOnly(t *testing.T) {
+	km := newTestMatcher(t, `{"busy_p1_s1": ["busy"], "later_p2_s1": ["call me later"]}`)
+
+	km.ExplainStage("busy", "s1")
+	km.LabelStage("busy, call me later", "s1")
+	km.peekStage("busy", "s1")
+	if stats := km.keywordStats(keywordStatsOptions{}); stats.Matches != 0 {
+		t.Errorf("Matches = %d after explain, labels and peek, want 0", stats.Matches)
+	}
+
+	km.matchStage("busy", "s1")
+	if stats := km.keywordStats(keywordStatsOptions{}); stats.Matches != 1 {
+		t.Errorf("Matches = %d after one match, want 1", stats.Matches)
+	}
+}
+
+// A keyword that matches but always loses to a higher priority is shadowed, not dead
+func TestKeywordStatsShadowed(t *testing.T) {
+	km := newTestMatcher(t, `{"busy_p1_s1": ["busy"], "callback_p2_s1": ["call me back", "ring me"]}`)
+	km.matchStage("busy, call me back tomorrow", "s1")
+
+	stats := km.keywordStats(keywordStatsOptions{})
+	if stats.Dead != 1 || stats.NeverWon != 1 {
+		t.Errorf("Dead = %d, NeverWon = %d; want 1 (ring me) and 1 (call me back)", stats.Dead, stats.NeverWon)
+	}
+	for _, category := range stats.Categories {
+		for _, kw := range category.Keywords {
+			if kw.Keyword == "call me back" && (kw.Hits != 0 || kw.Shadowed != 1) {
+				t.Errorf("call me back: %d hits, %d shadowed; want 0 and 1", kw.Hits, kw.Shadowed)
+			}
+		}
+	}
+
+	dead := km.keywordStats(keywordStatsOptions{deadOnly: true})
+	for _, category := range dead.Categories {
+		for _, kw := range category.Keywords {
+			if kw.Keyword != "ring me" {
+				t.Errorf("dead keywords list %q, want only ring me", kw.Keyword)
+			}
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// Keyword syntax of categories with the "pattern" match mode
+//
+//	call * later          "*" alone stands for one to five words
+//	not interest*         "*" inside a word stands for any word characters
+//	i am [really] busy    words in brackets are optional
+//	re:/(?:yes|yeah) sure/  a raw regular expression (RE2) over the normalized text
+//
+// Literal words are normalized like any keyword (lowercase, contractions, and the
+// campaign's number and disfluency settings); raw regexes are used as written and
+// see the normalized, lowercased text. Like literal keywords, a pattern only
+// matches at word boundaries. It is reported by the text it matched rather than
+// its source, and ranks by that text less the words taken by a standalone "*",
+// so a wildcard never outranks a literal keyword by swallowing extra words.
+// Patterns are validated when the campaign loads;
+// invalid ones are skipped with a warning that lint reports with its line.
+const (
+	regexKeywordPrefix      = "re:/"
+	maxKeywordPatternLength = 200  // Characters of a pattern keyword
+	maxKeywordPatternInsts  = 2000 // Instructions of its compiled program
+)
+
+// wildcardWordsExpr matches the one to maxPhraseWords words of a standalone "*"
+// It takes as few words as it can, and is the only capturing group of a
+// wildcard keyword so findPattern can leave its words out of the ranking.
+var wildcardWordsExpr = fmt.Sprintf(`(\S+(?: \S+){0,%d}?)`, maxPhraseWords-1)
+
+// compileKeywordPattern compiles a keyword of a pattern-enabled category
+// Keywords without pattern syntax return nil and are matched literally.
+func (km *KeywordMatcher) compileKeywordPattern(keyword string) (*regexp.Regexp, error) {
+	keyword = strings.TrimSpace(keyword)
+	isRegex := strings.HasPrefix(keyword, regexKeywordPrefix)
+	if !isRegex && !strings.ContainsAny(keyword, "*[]") {
+		return nil, nil
+	}
+	if len(keyword) > maxKeywordPatternLength {
+		return nil, fmt.Errorf("longer than %d characters", maxKeywordPatternLength)
+	}
+
+	var expr string
+	if isRegex {
+		if len(keyword) <= len(regexKeywordPrefix) || !strings.HasSuffix(keyword, "/") {
+			return nil, fmt.Errorf("regex keywords are written %s.../", regexKeywordPrefix)
+		}
+		expr = keyword[len(regexKeywordPrefix) : len(keyword)-1]
+	} else {
+		var err error
+		if expr, err = km.wildcardExpr(keyword); err != nil {
+			return nil, err
+		}
+	}
+
+	return compileBoundedRegex(expr)
+}
+
+// compileBoundedRegex compiles expr, rejecting expressions whose program is
+// larger than maxKeywordPatternInsts (e.g. large counted repetitions) and
+// expressions that match empty text
+func compileBoundedRegex(expr string) (*regexp.Regexp, error) {
+	parsed, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %v", err)
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %v", err)
+	}
+	if len(prog.Inst) > maxKeywordPatternInsts {
+		return nil, fmt.Errorf("too complex (%d instructions, limit %d)", len(prog.Inst), maxKeywordPatternInsts)
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %v", err)
+	}
+	if re.MatchString("") {
+		return nil, fmt.Errorf("matches empty text")
+	}
+	return re, nil
+}
+
+// patternElement is a run of words or an optional group
+type patternElement struct {
+	expr     string
+	optional bool
+}
+
+// wildcardExpr translates the wildcard and optional word syntax into a regex
+// over normalized text, where words are separated by single spaces
+func (km *KeywordMatcher) wildcardExpr(keyword string) (string, error) {
+	var elements []patternElement
+	hasLiteral := false
+
+	// addWords normalizes a run of words (keeping "*" inside words) and appends it
+	addWords := func(text string, optional bool) error {
+		words := strings.Fields(km.normalizeText(text))
+		if len(words) == 0 {
+			if optional {
+				return fmt.Errorf("empty optional group")
+			}
+			return nil
+		}
+		exprs := make([]string, 0, len(words))
+		for _, word := range words {
+			exprs = append(exprs, wildcardWordExpr(word))
+			if word != "*" && !optional {
+				hasLiteral = true
+			}
+		}
+		elements = append(elements, patternElement{
+			expr:     strings.Join(exprs, " "),
+			optional: optional,
+		})
+		return nil
+	}
+
+	rest := keyword
+	for rest != "" {
+		open := strings.IndexAny(rest, "[]")
+		if open < 0 {
+			if err := addWords(rest, false); err != nil {
+				return "", err
+			}
+			break
+		}
+		if rest[open] == ']' {
+			return "", fmt.Errorf("unmatched ]")
+		}
+		end := strings.IndexByte(rest[open:], ']')
+		if end < 0 {
+			return "", fmt.Errorf("unclosed [")
+		}
+		inner := rest[open+1 : open+end]
+		if strings.ContainsRune(inner, '[') {
+			return "", fmt.Errorf("nested [")
+		}
+		if err := addWords(rest[:open], false); err != nil {
+			return "", err
+		}
+		if err := addWords(inner, true); err != nil {
+			return "", err
+		}
+		rest = rest[open+end+1:]
+	}
+	if !hasLiteral {
+		return "", fmt.Errorf("needs at least one word that is neither optional nor *")
+	}
+
+	// Optional groups take the separating space with them so that leaving them
+	// out does not leave a double space
+	var b strings.Builder
+	separated := false
+	for _, el := range elements {
+		switch {
+		case !el.optional:
+			if separated {
+				b.WriteString(" ")
+			}
+			b.WriteString(el.expr)
+			separated = true
+		case separated:
+			b.WriteString("(?: " + el.expr + ")?")
+		default:
+			b.WriteString("(?:" + el.expr + " )?")
+		}
+	}
+	return `\b(?:` + b.String() + `)\b`, nil
+}
+
+// wildcardWordExpr translates one normalized word: "*" alone stands for one or
+// more words, "*" inside a word for any word characters
+func wildcardWordExpr(word string) string {
+	if word == "*" {
+		return wildcardWordsExpr
+	}
+	parts := strings.Split(word, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return strings.Join(parts, `\w*`)
+}
+
+// findPattern calls found for every match of a pattern keyword in normalized,
+// with the length of the match less the text matched by standalone "*" (literal)
+// The regex only reports the leftmost of overlapping matches, so when that one
+// is not on word boundaries (see spanKinds) the search resumes right after its
+// start, where a valid overlapping match may begin. Resumed searches use
+// entry.resume, because ^ must not match where the search resumes.
+func (entry *keywordEntry) findPattern(normalized string, found func(start, end, literal int)) {
+	re := entry.regex
+	for offset := 0; offset <= len(normalized); re = entry.resume {
+		loc := re.FindStringSubmatchIndex(normalized[offset:])
+		if loc == nil {
+			return
+		}
+		start, end := offset+loc[0], offset+loc[1]
+
+		exact, phrase, substring := spanKinds(normalized, start, end, strings.Count(normalized[start:end], " ")+1)
+		if end > start && (exact || phrase || substring) {
+			literal := end - start
+			if entry.wildcards {
+				for g := 2; g+1 < len(loc); g += 2 {
+					if loc[g] >= 0 {
+						literal -= loc[g+1] - loc[g]
+					}
+				}
+			}
+			found(start, end, literal)
+			offset = end
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(normalized[start:])
+		offset = start + max(size, 1)
+	}
+}
+
+// resumeRegex returns re with the anchors for the beginning of the text
+// replaced by an expression that never matches, for searches that start inside
+// the text. It returns re itself when it has no such anchor.
+func resumeRegex(re *regexp.Regexp) *regexp.Regexp {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil || !dropBeginAnchors(parsed) {
+		return re
+	}
+	resume, err := regexp.Compile(parsed.String())
+	if err != nil {
+		return re
+	}
+	return resume
+}
+
+// dropBeginAnchors replaces ^ and \A in re with a no-match and reports whether it found any
+func dropBeginAnchors(re *syntax.Regexp) bool {
+	if re.Op == syntax.OpBeginText || re.Op == syntax.OpBeginLine {
+		*re = syntax.Regexp{Op: syntax.OpNoMatch}
+		return true
+	}
+	found := false
+	for _, sub := range re.Sub {
+		if dropBeginAnchors(sub) {
+			found = true
+		}
+	}
+	return found
+}
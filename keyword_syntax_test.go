@@ -0,0 +1,165 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// newTestMatcher builds a matcher from an inline campaign file
+func newTestMatcher(t *testing.T, data string) *KeywordMatcher {
+	t.Helper()
+	km, err := newKeywordMatcherFromData("test.json", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return km
+}
+
+func TestPatternKeywords(t *testing.T) {
+	km := newTestMatcher(t, `{
+		"settings": {"match_modes": {"busy_p1_s1": ["pattern"], "notInterested_p2_s1": ["pattern"], "yes_p4_s1": ["pattern"]}},
+		"busy_p1_s1": ["call * later", "i am [really] busy", "[just] driving"],
+		"notInterested_p2_s1": ["not interest*", "re:/(?:no|nope) thanks?/"],
+		"literal_p3_s1": ["re:/maybe/", "hello [world]"],
+		"yes_p4_s1": ["re:/(?:yes|yeah) sure/"],
+		"yesThing_p4_s1": ["yes sure thing"]
+	}`)
+
+	tests := []struct {
+		text, result, keyword string
+	}{
+		// "*" alone stands for one to five words
+		{"please call me back later", "busy", "call me back later"},
+		{"call later", "unknown", ""},
+		{"call one two three four five six later", "unknown", ""},
+		// Optional words
+		{"i am busy", "busy", "i am busy"},
+		{"i'm really busy", "busy", "i am really busy"},
+		{"i am very busy", "unknown", ""},
+		{"driving", "busy", "driving"},
+		{"just driving right now", "busy", "just driving"},
+		// "*" inside a word
+		{"i am not interested", "notinterested", "not interested"},
+		{"not interesting at all", "notinterested", "not interesting"},
+		// Raw regex, only at word boundaries
+		{"nope thanks", "notinterested", "nope thanks"},
+		{"no thank you", "notinterested", "no thank"},
+		{"piano thanks", "unknown", ""},
+		// Without the pattern mode, the syntax is matched literally
+		{"maybe", "unknown", ""},
+		{"re:/maybe/", "literal", "re:/maybe/"},
+		{"hello world", "unknown", ""},
+		// Pattern keywords rank by the text they matched, not their source
+		{"well yes sure thing", "yesthing", "yes sure thing"},
+		{"yeah sure", "yes", "yeah sure"},
+	}
+	for _, tt := range tests {
+		result := km.peekStage(tt.text, "s1")
+		got, keyword := returnValueOf(result), ""
+		if result != nil {
+			keyword = result.keyword
+		}
+		if got != tt.result || keyword != tt.keyword {
+			t.Errorf("%q matched %s (%q), want %s (%q)", tt.text, got, keyword, tt.result, tt.keyword)
+		}
+	}
+}
+
+// A leftmost regex match off word boundaries must not hide an overlapping one
+func TestPatternKeywordOverlappingMatches(t *testing.T) {
+	km := newTestMatcher(t, `{
+		"settings": {"match_modes": {"no_p1_s1": ["pattern"]}},
+		"no_p1_s1": ["re:/no (?:no )?thanks/"]
+	}`)
+	result := km.peekStage("xno no thanks", "s1")
+	if result == nil || result.keyword != "no thanks" {
+		t.Fatalf("got %+v, want a match on %q", result, "no thanks")
+	}
+}
+
+// Words taken by a standalone "*" do not count when a pattern ties on priority
+// with a literal keyword; the wildcard category comes first in the file, so it
+// would win any tie on length
+func TestWildcardTiesLiteralKeyword(t *testing.T) {
+	km := newTestMatcher(t, `{
+		"settings": {"match_modes": {"wildcard_p1_s1": ["pattern"]}},
+		"wildcard_p1_s1": ["call me *", "ring * later"],
+		"literal_p1_s1": ["call me back", "me back later now"]
+	}`)
+
+	tests := []struct {
+		text, result, keyword string
+	}{
+		{"call me back later today", "literal", "call me back"},
+		{"please ring me back later now", "literal", "me back later now"},
+		// Alone, "*" takes as few words as it can
+		{"call me maybe later today", "wildcard", "call me maybe"},
+		{"ring me back tomorrow later", "wildcard", "ring me back tomorrow later"},
+	}
+	for _, tt := range tests {
+		result := km.peekStage(tt.text, "s1")
+		got, keyword := returnValueOf(result), ""
+		if result != nil {
+			keyword = result.keyword
+		}
+		if got != tt.result || keyword != tt.keyword {
+			t.Errorf("%q matched %s (%q), want %s (%q)", tt.text, got, keyword, tt.result, tt.keyword)
+		}
+	}
+}
+
+func TestResumeRegex(t *testing.T) {
+	re := regexp.MustCompile(`^yes|no`)
+	resume := resumeRegex(re)
+	if resume.MatchString("yes") || !resume.MatchString("no") {
+		t.Errorf("resumeRegex(%s) = %s, want ^ to never match", re, resume)
+	}
+	if plain := regexp.MustCompile(`yes|no`); resumeRegex(plain) != plain {
+		t.Errorf("resumeRegex of a regex without anchors should return it unchanged")
+	}
+}
+
+func TestCompileKeywordPatternRejects(t *testing.T) {
+	km := newTestMatcher(t, `{}`)
+	tests := []struct {
+		keyword, err string
+	}{
+		{"re:/(a/", "invalid regex"},
+		{"re:/nope", "regex keywords are written"},
+		{"re:/(?:abcd){1000}/", "too complex"},
+		{"re:/" + strings.Repeat("a", maxKeywordPatternLength) + "/", "longer than"},
+		{"re:/x*/", "matches empty text"},
+		{"re:/(?:)/", "matches empty text"},
+		{"[optional]", "needs at least one word"},
+		{"* *", "needs at least one word"},
+		{"i am [unclosed", "unclosed ["},
+		{"i am ] busy", "unmatched ]"},
+		{"i am [[really]] busy", "nested ["},
+		{"i am [] busy", "empty optional group"},
+	}
+	for _, tt := range tests {
+		re, err := km.compileKeywordPattern(tt.keyword)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("compileKeywordPattern(%q) = %v, %v; want error containing %q", tt.keyword, re, err, tt.err)
+		}
+	}
+
+	if re, err := km.compileKeywordPattern("plain keyword"); re != nil || err != nil {
+		t.Errorf("compileKeywordPattern of a literal keyword = %v, %v; want nil, nil", re, err)
+	}
+}
+
+// Invalid pattern keywords are skipped with a warning at their JSON path
+func TestInvalidPatternKeywordWarning(t *testing.T) {
+	km := newTestMatcher(t, `{
+		"settings": {"match_modes": {"busy_p1_s1": ["pattern"]}},
+		"busy_p1_s1": ["i am busy", "re:/x*/"]
+	}`)
+	if len(km.warnings) != 1 || km.warnings[0].path != "busy_p1_s1/1" {
+		t.Fatalf("warnings = %+v, want one for busy_p1_s1/1", km.warnings)
+	}
+	if keywords := km.stageMap["s1"].Prioritized[0].Keywords; len(keywords) != 1 {
+		t.Errorf("got %d keywords, want the invalid one skipped", len(keywords))
+	}
+}
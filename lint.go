@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// lintFinding is one problem reported by the lint subcommand
+type lintFinding struct {
+	line    int
+	message string
+}
+
+// runLint implements the "lint" subcommand.
+// Every campaign is loaded through loadKeywordMatcher (the same path the server
+// uses) and checked for load warnings, duplicate keywords, keywords shadowed by
+// a higher priority category, and categories that can never win.
+// Exits non-zero when any problem is found.
+//
+//	./main lint                      # every campaign in ./keywords
+//	./main lint fe_basic other.json  # selected campaigns or files
+func runLint(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	keywordsDir := fs.String("keywords", "keywords", "keywords directory")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	files := make([]string, 0)
+	for _, arg := range fs.Args() {
+		if strings.HasSuffix(arg, ".json") {
+			files = append(files, arg)
+		} else {
+			files = append(files, filepath.Join(*keywordsDir, arg+".json"))
+		}
+	}
+	if len(files) == 0 {
+		matches, err := filepath.Glob(filepath.Join(*keywordsDir, "*.json"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "lint: %v\n", err)
+			return 2
+		}
+		files = matches
+	}
+
+	// Loading logs every warning; lint reports them itself
+	log.SetOutput(io.Discard)
+
+	problems := 0
+	for _, file := range files {
+		findings := lintCampaignFile(file)
+		for _, f := range findings {
+			fmt.Printf("%s:%d: %s\n", file, f.line, f.message)
+		}
+		problems += len(findings)
+	}
+
+	if problems > 0 {
+		fmt.Fprintf(os.Stderr, "lint: %d problem(s) in %d file(s)\n", problems, len(files))
+		return 1
+	}
+	return 0
+}
+
+// lintCampaignFile loads one campaign file and returns its findings sorted by line
+func lintCampaignFile(filePath string) []lintFinding {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return []lintFinding{{line: 0, message: err.Error()}}
+	}
+
+	km, err := loadKeywordMatcher(filePath)
+	if err != nil {
+		line := 1
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line = bytes.Count(data[:syntaxErr.Offset], []byte("\n")) + 1
+		}
+		return []lintFinding{{line: line, message: err.Error()}}
+	}
+
+	lines := jsonLineIndex(data)
+	findings := lintMatcher(km, lines)
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].line < findings[j].line })
+	return findings
+}
+
+// lintMatcher checks a loaded matcher; lines maps JSON paths to file lines
+func lintMatcher(km *KeywordMatcher, lines map[string]int) []lintFinding {
+	findings := make([]lintFinding, 0)
+	add := func(path, format string, args ...interface{}) {
+		findings = append(findings, lintFinding{line: lineOfPath(lines, path), message: fmt.Sprintf(format, args...)})
+	}
+
+	// Problems already found while building the matcher
+	for _, w := range km.warnings {
+		add(w.path, "%s", w.message)
+	}
+
+	stages := make([]string, 0, len(km.stageMap))
+	for stage := range km.stageMap {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+
+	for _, stage := range stages {
+		sc := km.stageMap[stage]
+
+		type firstSeen struct {
+			category *CategoryEntry
+			group    int
+		}
+		seen := make(map[string]firstSeen)
+
+		for g := range sc.groups {
+			for c := range sc.groups[g].categories {
+				catEntry := &sc.groups[g].categories[c]
+				reachable := false
+
+				for k := range catEntry.Keywords {
+					entry := &catEntry.Keywords[k]
+					if entry.negatedOnly {
+						reachable = true
+						continue
+					}
+					path := jsonPath(catEntry.keywordsPath, strconv.Itoa(entry.source))
+
+					// Duplicates within the stage
+					if first, ok := seen[entry.raw]; ok {
+						if first.category == catEntry {
+							add(path, "duplicate keyword %q in %s", entry.raw, catEntry.Info.Key)
+						} else {
+							add(path, "duplicate keyword %q: also in %s (%s)", entry.raw, first.category.Info.Key, priorityLabel(first.category))
+						}
+						if first.group < g {
+							continue
+						}
+					} else {
+						seen[entry.raw] = firstSeen{category: catEntry, group: g}
+					}
+
+					// Shadowed by a keyword of a higher priority group (pattern keywords
+					// match many texts, so they are not checked)
+					if entry.regex != nil {
+						reachable = true
+						continue
+					}
+					if shadow, shadowCat := sc.shadowingKeyword(entry.raw, g); shadowCat != nil {
+						add(path, "keyword %q in %s (%s) is shadowed by %q in %s (%s)",
+							entry.raw, catEntry.Info.Key, priorityLabel(catEntry),
+							shadow, shadowCat.Info.Key, priorityLabel(shadowCat))
+						continue
+					}
+
+					reachable = true
+				}
+
+				if !reachable && len(catEntry.Keywords) > 0 { // Categories left without keywords were already warned about
+					add(catEntry.keywordsPath, "category %s is unreachable: every keyword is shadowed by a higher priority category", catEntry.Info.Key)
+				}
+			}
+		}
+	}
+
+	return findings
+}
+
+// shadowingKeyword returns a keyword of a group checked before group that occurs
+// in text with word boundaries, together with its category. Any utterance that
+// contains text then also contains that keyword, so the earlier group always wins.
+// Negatable keywords are ignored because a negator can suppress them.
+func (sc *StageCategories) shadowingKeyword(text string, group int) (string, *CategoryEntry) {
+	var keyword string
+	var category *CategoryEntry
+	best := -1
+
+	sc.index.scan(text, func(pattern, start, end int) {
+		ref := sc.patterns[pattern]
+		if ref.group >= group || ref.exclude || (best >= 0 && pattern >= best) {
+			return
+		}
+		entry := sc.entryOf(keywordHit{pattern: pattern})
+		if entry.negatable || entry.negatedOnly {
+			return
+		}
+		if isWordBoundary(text, start) && isWordBoundary(text, end) {
+			best = pattern
+			keyword = entry.raw
+			category = sc.categoryOf(keywordHit{pattern: pattern})
+		}
+	})
+
+	return keyword, category
+}
+
+// priorityLabel formats the priority of a category as written in flat keys
+func priorityLabel(catEntry *CategoryEntry) string {
+	if catEntry.Info.IsHardcoded {
+		return "hardcoded"
+	}
+	return fmt.Sprintf("p%d", catEntry.Info.Priority)
+}
+
+// jsonLineIndex maps the JSON path of every object member and array element
+// (see jsonPath) to the line it starts on
+func jsonLineIndex(data []byte) map[string]int {
+	lines := make(map[string]int)
+	walkJSON(data, func(path, parent string, isKey bool, offset int) {
+		lines[path] = bytes.Count(data[:offset], []byte("\n")) + 1
+	})
+	return lines
+}
+
+// lineOfPath returns the line of path, or of its closest known parent
+func lineOfPath(lines map[string]int, path string) int {
+	for {
+		if line, ok := lines[path]; ok {
+			return line
+		}
+		i := strings.LastIndex(path, "/")
+		if i < 0 {
+			return 1
+		}
+		path = path[:i]
+	}
+}
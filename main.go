@@ -3,12 +3,29 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
 func main() {
+	// Offline subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "lint":
+			os.Exit(runLint(os.Args[2:]))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "evaluate":
+			os.Exit(runEvaluate(os.Args[2:]))
+		case "keyword-stats":
+			os.Exit(runKeywordStats(os.Args[2:]))
+		}
+	}
+
 	// Initialize campaign cache with file watcher
 	keywordsDir := "keywords"
 	var err error
@@ -21,22 +38,110 @@ func main() {
 	// Start file watcher in background
 	go campaignCache.WatchFiles()
 
+	// Sessions expire after SESSION_TTL of inactivity (e.g. "30m")
+	sessionTTL := defaultSessionTTL
+	if ttl, err := time.ParseDuration(os.Getenv("SESSION_TTL")); err == nil && ttl > 0 {
+		sessionTTL = ttl
+	}
+	// and at most MAX_SESSIONS are live at once
+	maxSessions := defaultMaxSessions
+	if n, err := strconv.Atoi(os.Getenv("MAX_SESSIONS")); err == nil && n > 0 {
+		maxSessions = n
+	}
+	sessionStore = NewSessionStore(sessionTTL, maxSessions)
+	go sessionStore.ExpireSessions(time.Minute)
+
+	// Optional capture of every match to a rotating JSONL file (see runReplay)
+	if path := os.Getenv("CAPTURE_FILE"); path != "" {
+		maxMB, maxFiles := 100, 5
+		if mb, err := strconv.Atoi(os.Getenv("CAPTURE_MAX_MB")); err == nil && mb > 0 {
+			maxMB = mb
+		}
+		if files, err := strconv.Atoi(os.Getenv("CAPTURE_MAX_FILES")); err == nil && files >= 0 {
+			maxFiles = files
+		}
+		capture, err = newCaptureWriter(path, int64(maxMB)<<20, maxFiles)
+		if err != nil {
+			log.Fatalf("Failed to initialize capture: %v", err)
+		}
+		defer capture.Close()
+		log.Printf("Capturing matches to %s (rotating at %d MB, keeping %d files)", path, maxMB, maxFiles)
+	}
+
+	// API keys (AUTH_KEYS_FILE); without it every route is open
+	auth, err := loadAuthenticator(os.Getenv("AUTH_KEYS_FILE"))
+	if err != nil {
+		log.Fatalf("Failed to initialize authentication: %v", err)
+	}
+	if auth == nil {
+		log.Printf("Warning: AUTH_KEYS_FILE is not set, match and admin routes are unauthenticated")
+	}
+	matchAuth := auth.require(roleMatch, false)
+	adminAuth := auth.require(roleAdmin, false)
+	globalAdminAuth := auth.require(roleAdmin, true)
+	metricsAuth := auth.require(roleMetrics, true)
+
 	e := echo.New()
 
 	// Middleware
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
-	e.Use(middleware.CORS())
+
+	// Cross-origin requests are only allowed from CORS_ALLOW_ORIGINS
+	// (comma separated, "*" for any origin)
+	if origins := os.Getenv("CORS_ALLOW_ORIGINS"); origins != "" {
+		allowed := make([]string, 0)
+		for _, origin := range strings.Split(origins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				allowed = append(allowed, origin)
+			}
+		}
+		streamAllowedOrigins = allowed
+		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins: allowed,
+			AllowHeaders: []string{
+				echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, "If-Match",
+				headerAPIKey, headerTimestamp, headerSignature, headerNonce,
+			},
+			ExposeHeaders: []string{"ETag"},
+		}))
+		log.Printf("CORS allowed origins: %s", strings.Join(allowed, ", "))
+	}
 
 	// Routes
-	e.POST("/match", handleMatch)
-	e.GET("/match", handleMatch)
+	e.POST("/match", handleMatch, matchAuth)
+	e.GET("/match", handleMatch, matchAuth)
+	e.POST("/match/explain", handleMatchExplain, matchAuth)
+	e.GET("/match/explain", handleMatchExplain, matchAuth)
+	e.POST("/match/labels", handleMatchLabels, matchAuth)
+	e.GET("/match/labels", handleMatchLabels, matchAuth)
+	e.POST("/match/batch", handleMatchBatch, matchAuth)
+	e.GET("/match/stream", handleStream, matchAuth)
 	e.GET("/health", handleHealth)
+	e.GET("/metrics", handleMetrics, metricsAuth)
+
+	// Conversation sessions keyed by call ID
+	e.POST("/sessions", handleCreateSession, matchAuth)
+	e.GET("/sessions/:id", handleGetSession, matchAuth)
+	e.POST("/sessions/:id/match", handleSessionMatch, matchAuth)
 
 	// Admin endpoints for manual reload
-	e.POST("/admin/reload/:campaign", handleReloadCampaign)
-	e.POST("/admin/reload-all", handleReloadAll)
-	e.GET("/admin/cache-info", handleCacheInfo)
+	e.POST("/admin/reload/:campaign", handleReloadCampaign, adminAuth)
+	e.POST("/admin/reload-all", handleReloadAll, globalAdminAuth)
+	e.GET("/admin/cache-info", handleCacheInfo, globalAdminAuth)
+
+	// Campaign file editing; writes require If-Match with the ETag from a read
+	e.GET("/admin/campaigns/:campaign/categories", handleListCategories, adminAuth)
+	e.POST("/admin/campaigns/:campaign/categories", handleCreateCategory, adminAuth)
+	e.GET("/admin/campaigns/:campaign/categories/:category", handleGetCategory, adminAuth)
+	e.PATCH("/admin/campaigns/:campaign/categories/:category", handleUpdateCategory, adminAuth)
+	e.DELETE("/admin/campaigns/:campaign/categories/:category", handleDeleteCategory, adminAuth)
+	e.POST("/admin/campaigns/:campaign/categories/:category/keywords", handleAddKeywords, adminAuth)
+	e.DELETE("/admin/campaigns/:campaign/categories/:category/keywords", handleRemoveKeywords, adminAuth)
+
+	// Keyword hit counters
+	e.GET("/admin/keyword-stats/:campaign", handleKeywordStats, adminAuth)
+	e.POST("/admin/keyword-stats/:campaign/reset", handleResetKeywordStats, adminAuth)
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -44,6 +149,10 @@ func main() {
 		port = "8050"
 	}
 
+	if workers, err := strconv.Atoi(os.Getenv("BATCH_WORKERS")); err == nil && workers > 0 {
+		batchWorkers = workers
+	}
+
 	log.Printf("Keyword Matcher started on port %s", port)
 	log.Printf("Watching keywords directory: %s", keywordsDir)
 	log.Printf("Auto-reload enabled for keyword files")
@@ -5,20 +5,32 @@ import (
 	"log"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 	"unicode"
 
 	"golang.org/x/text/unicode/norm"
 )
 
+// maxPhraseWords is the longest n-gram produced by tokenize
+const maxPhraseWords = 5
+
+var multiSpaceRegex = regexp.MustCompile(`\s+`)
+
 // NewKeywordMatcher creates a matcher with dynamic category parsing
 // Categories are parsed from JSON keys following the pattern: {category}_{priority}_{stage}
 // Example: "donotcall_p1_s3" -> category="donotcall", priority=1, stage="s3"
 // Hardcoded example: "honeypot_hardcoded_s2" -> category="honeypot", hardcoded=true, stage="s2"
-func NewKeywordMatcher(rawKeywords FlexibleKeywordSets, filePath string) *KeywordMatcher {
+// Files with "version": 2 use category objects instead (see CategoryDefinitionV2);
+// both formats produce the same StageCategories.
+// order is the key order of the file (see parseCampaignData); categories sharing
+// a priority are checked in that order. With a nil order keys are sorted.
+func NewKeywordMatcher(rawKeywords FlexibleKeywordSets, order KeyOrder, filePath string) *KeywordMatcher {
 	km := &KeywordMatcher{
 		stageMap: make(map[string]*StageCategories),
+		settings: defaultCampaignSettings(),
 		loadedAt: time.Now(),
 		filePath: filePath,
 		contractions: map[string]string{
@@ -36,22 +48,46 @@ func NewKeywordMatcher(rawKeywords FlexibleKeywordSets, filePath string) *Keywor
 		},
 	}
 
-	// Parse all categories from JSON dynamically
-	for categoryKey, value := range rawKeywords {
-		info := parseCategoryName(categoryKey)
-		if info == nil {
-			log.Printf("Warning: Could not parse category name: %s", categoryKey)
-			continue
+	// Campaign settings are needed before the categories are built
+	if value, ok := rawKeywords[settingsKey]; ok {
+		settings, err := parseCampaignSettings(value)
+		if err != nil {
+			km.warnf(settingsKey, "Invalid campaign settings, using defaults: %v", err)
+		}
+		km.settings = settings
+	}
+	km.prepareFillers()
+	if threshold := km.settings.Confidence.Threshold; threshold < 0 || threshold > 1 {
+		km.warnf(jsonPath(settingsKey, "confidence", "threshold"), "Confidence threshold %v is outside 0 to 1", threshold)
+	}
+
+	// Parse all categories from JSON dynamically (flat or version 2 format)
+	definitions := km.categoryDefinitions(rawKeywords, order)
+
+	known := make(map[string]bool, len(definitions))
+	for _, def := range definitions {
+		known[def.Info.Key] = true
+		for _, mode := range def.MatchModes {
+			if mode != matchModeFuzzy && mode != matchModePhonetic && mode != matchModePattern {
+				km.warnf(def.Path, "Unknown match mode %q for category %s", mode, def.Info.Key)
+			}
+		}
+	}
+	for _, categoryKey := range orderedKeys(stringListsToInterfaces(km.settings.MatchModes), order[jsonPath(settingsKey, "match_modes")]) {
+		if !known[categoryKey] {
+			km.warnf(jsonPath(settingsKey, "match_modes", categoryKey), "match_modes refers to unknown category: %s", categoryKey)
 		}
+	}
 
-		// Convert keywords to string slice
-		keywords := km.convertToStringSlice(value)
-		if len(keywords) == 0 {
+	for _, def := range definitions {
+		info := def.Info
+		if len(def.Keywords) == 0 {
 			continue
 		}
 
-		// Prepare keyword entries with regex
-		entries := km.prepareKeywordEntries(keywords)
+		// Normalize keywords
+		patterns := hasMode(def.MatchModes, matchModePattern)
+		entries := km.prepareKeywordEntries(def.KeywordsPath, def.Keywords, patterns)
 
 		// Initialize stage map if needed
 		if _, exists := km.stageMap[info.Stage]; !exists {
@@ -63,8 +99,14 @@ func NewKeywordMatcher(rawKeywords FlexibleKeywordSets, filePath string) *Keywor
 
 		// Add to appropriate list
 		categoryEntry := CategoryEntry{
-			Info:     *info,
+			Info:     info,
 			Keywords: entries,
+			Excludes: km.prepareKeywordEntries(def.ExcludesPath, def.Excludes, patterns),
+
+			keywordsPath: def.KeywordsPath,
+			excludesPath: def.ExcludesPath,
+			Fuzzy:        hasMode(def.MatchModes, matchModeFuzzy),
+			Phonetic:     hasMode(def.MatchModes, matchModePhonetic),
 		}
 
 		if info.IsHardcoded {
@@ -74,13 +116,29 @@ func NewKeywordMatcher(rawKeywords FlexibleKeywordSets, filePath string) *Keywor
 		}
 	}
 
+	// Stage transitions used by sessions
+	km.checkTransitions(order)
+
+	// Mark negatable keywords (rerouted copies are added to their opposite categories)
+	km.applyNegationRules()
+	negators := make([]string, 0, len(km.settings.Negation.Negators))
+	for _, negator := range km.settings.Negation.Negators {
+		negators = append(negators, km.normalizeText(negator))
+	}
+	negation := newNegationDetector(negators, km.settings.Negation.Window)
+
 	// Sort prioritized categories by priority for each stage
+	// The sort is stable so categories sharing a priority stay in file order
 	for stage, stageData := range km.stageMap {
-		sort.Slice(stageData.Prioritized, func(i, j int) bool {
+		sort.SliceStable(stageData.Prioritized, func(i, j int) bool {
 			return stageData.Prioritized[i].Info.Priority < stageData.Prioritized[j].Info.Priority
 		})
-		log.Printf("Loaded stage %s: %d hardcoded categories, %d prioritized categories",
-			stage, len(stageData.Hardcoded), len(stageData.Prioritized))
+
+		// Compile the whole stage into one automaton
+		stageData.buildIndex(&km.settings, negation)
+
+		log.Printf("Loaded stage %s: %d hardcoded categories, %d prioritized categories, %d keywords",
+			stage, len(stageData.Hardcoded), len(stageData.Prioritized), len(stageData.patterns))
 	}
 
 	return km
@@ -108,6 +166,7 @@ func parseCategoryName(name string) *CategoryInfo {
 	priorityPart := parts[len(parts)-2]
 
 	info := CategoryInfo{
+		Key:   name,
 		Stage: stage,
 	}
 
@@ -160,9 +219,9 @@ func (km *KeywordMatcher) convertToStringSlice(value interface{}) []string {
 	case []string:
 		result = v
 	case map[string]interface{}:
-		// If it's a dict, use the values
-		for _, val := range v {
-			if str, ok := val.(string); ok {
+		// If it's a dict, use the values (ordered by key)
+		for _, key := range orderedKeys(v, nil) {
+			if str, ok := v[key].(string); ok {
 				result = append(result, str)
 			}
 		}
@@ -174,32 +233,76 @@ func (km *KeywordMatcher) convertToStringSlice(value interface{}) []string {
 	return result
 }
 
-// prepareKeywordEntries normalizes keywords and creates regex patterns
-func (km *KeywordMatcher) prepareKeywordEntries(keywords []string) []keywordEntry {
+// prepareKeywordEntries normalizes keywords for the stage automaton and computes
+// the phonetic code of each keyword word
+// path is the JSON path of the keyword list, used to report empty keywords
+// With patterns set, keywords using the pattern syntax are compiled to a regex
+// (see compileKeywordPattern) and invalid ones are skipped.
+func (km *KeywordMatcher) prepareKeywordEntries(path string, keywords []string, patterns bool) []keywordEntry {
 	entries := make([]keywordEntry, 0, len(keywords))
 
-	for _, kw := range keywords {
+	for i, kw := range keywords {
+		if patterns {
+			re, err := km.compileKeywordPattern(kw)
+			if err != nil {
+				km.warnf(jsonPath(path, strconv.Itoa(i)), "Invalid keyword pattern %q: %v", kw, err)
+				continue
+			}
+			if re != nil {
+				raw := strings.ToLower(strings.TrimSpace(kw))
+				entries = append(entries, keywordEntry{
+					raw:       raw,
+					words:     strings.Count(raw, " ") + 1,
+					regex:     re,
+					resume:    resumeRegex(re),
+					wildcards: !strings.HasPrefix(raw, regexKeywordPrefix),
+					source:    i,
+				})
+				continue
+			}
+		} else if strings.HasPrefix(strings.TrimSpace(kw), regexKeywordPrefix) {
+			km.warnf(jsonPath(path, strconv.Itoa(i)), "Keyword %q is matched literally; the category needs the %q match mode to use it as a regex", kw, matchModePattern)
+		}
+
 		normalized := km.normalizeText(kw)
-		if normalized != "" {
-			// Precompile regex pattern with word boundaries
-			pattern := `\b` + regexp.QuoteMeta(normalized) + `\b`
-			re := regexp.MustCompile(pattern)
-
-			entries = append(entries, keywordEntry{
-				raw:   normalized,
-				regex: re,
-			})
+		if normalized == "" {
+			km.warnf(jsonPath(path, strconv.Itoa(i)), "Keyword %q normalizes to an empty string", kw)
+			continue
 		}
+		entries = append(entries, keywordEntry{
+			raw:      normalized,
+			words:    strings.Count(normalized, " ") + 1,
+			phonetic: phoneticKey(strings.Fields(normalized)),
+			source:   i,
+		})
 	}
 
 	return entries
 }
 
+// warnf logs a problem found while loading the campaign and records it with the
+// JSON path it refers to, so the lint subcommand can report file positions
+func (km *KeywordMatcher) warnf(path, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Printf("Warning: %s", message)
+	km.warnings = append(km.warnings, loadWarning{path: path, message: message})
+}
+
+// jsonPath joins object keys and array indexes into a path inside a campaign file
+// e.g. jsonPath("categories", "busy", "keywords", "3") -> "categories/busy/keywords/3"
+func jsonPath(parts ...string) string {
+	return strings.Join(parts, "/")
+}
+
 // normalizeText performs text normalization (same as before)
 // - Unicode normalization
 // - Lowercase conversion
 // - Contraction expansion
+// - Disfluency filter, if enabled for the campaign (see DisfluencySettings)
+// - Number canonicalization, if enabled for the campaign (see canonicalizeNumbers)
 // - Whitespace normalization
+// Annotations and fillers are dropped before numbers are read; repetitions are
+// collapsed after, so "five five five" stays a phone number.
 func (km *KeywordMatcher) normalizeText(text string) string {
 	// Normalize unicode
 	text = norm.NFKD.String(text)
@@ -214,6 +317,9 @@ func (km *KeywordMatcher) normalizeText(text string) string {
 
 	// Convert to lowercase
 	text = strings.ToLower(strings.TrimSpace(text))
+	if km.settings.Disfluency.Enabled && !km.settings.Disfluency.KeepAnnotations {
+		text = stripAnnotations(text)
+	}
 
 	// Expand contractions
 	words := strings.Fields(text)
@@ -222,10 +328,20 @@ func (km *KeywordMatcher) normalizeText(text string) string {
 			words[i] = expansion
 		}
 	}
+	disfluency := &km.settings.Disfluency
+	if disfluency.Enabled {
+		words = km.removeFillers(words)
+	}
+	if km.settings.Numbers.Enabled {
+		words = canonicalizeNumbers(words)
+	}
+	if disfluency.Enabled && !disfluency.KeepRepeats {
+		words = collapseRepeats(words)
+	}
 	text = strings.Join(words, " ")
 
 	// Normalize multiple spaces
-	text = regexp.MustCompile(`\s+`).ReplaceAllString(text, " ")
+	text = multiSpaceRegex.ReplaceAllString(text, " ")
 
 	return text
 }
@@ -250,7 +366,7 @@ func (km *KeywordMatcher) tokenize(text string) []string {
 	}
 
 	// Longer phrases (4-5 words)
-	for length := 4; length <= 5 && length <= len(words); length++ {
+	for length := 4; length <= maxPhraseWords && length <= len(words); length++ {
 		for i := 0; i <= len(words)-length; i++ {
 			tokens = append(tokens, strings.Join(words[i:i+length], " "))
 		}
@@ -259,64 +375,381 @@ func (km *KeywordMatcher) tokenize(text string) []string {
 	return tokens
 }
 
-// findBestMatch finds the best keyword match from a list of category entries
-// Matching priority: exact match > phrase match > substring match (with word boundaries)
-// Returns the longest match found
-func (km *KeywordMatcher) findBestMatch(text string, categories []CategoryEntry) *matchResult {
-	normalized := km.normalizeText(text)
+// buildIndex compiles every keyword of the stage into a single automaton.
+// Pattern ids are assigned in check order (hardcoded categories first, then
+// prioritized categories in priority order, keywords in file order), so sorting
+// hits by pattern id reproduces the order of a category-by-category scan.
+// Keywords of fuzzy-enabled categories are also added to the fuzzy index, and the
+// phonetic keys of phonetic-enabled categories get an automaton of their own.
+// The negation detector is only kept when the stage has negatable keywords.
+func (sc *StageCategories) buildIndex(settings *CampaignSettings, negation *negationDetector) {
+	sc.groups = sc.groups[:0]
+	if len(sc.Hardcoded) > 0 {
+		sc.groups = append(sc.groups, categoryGroup{
+			categories: sc.Hardcoded,
+			hardcoded:  true,
+		})
+	}
+	for start := 0; start < len(sc.Prioritized); {
+		end := start + 1
+		for end < len(sc.Prioritized) && sc.Prioritized[end].Info.Priority == sc.Prioritized[start].Info.Priority {
+			end++
+		}
+		sc.groups = append(sc.groups, categoryGroup{
+			categories: sc.Prioritized[start:end],
+			priority:   sc.Prioritized[start].Info.Priority,
+		})
+		start = end
+	}
 
-	// First: Check for exact matches across all categories
-	for _, catEntry := range categories {
-		for _, entry := range catEntry.Keywords {
-			if entry.raw == normalized {
-				return &matchResult{
-					keyword:     normalized,
-					matchType:   "exact",
-					length:      len(normalized),
-					category:    catEntry.Info.BaseName,
-					returnValue: catEntry.Info.ReturnValue,
+	var keywords, phoneticKeys []string
+	sc.patterns = sc.patterns[:0]
+	sc.fuzzy = nil
+	sc.phonetic = nil
+	sc.phoneticPatterns = sc.phoneticPatterns[:0]
+	sc.regexPatterns = sc.regexPatterns[:0]
+	sc.negation = nil
+	for g := range sc.groups {
+		group := &sc.groups[g]
+		group.firstPattern = len(sc.patterns)
+		for c, catEntry := range group.categories {
+			if catEntry.Fuzzy && sc.fuzzy == nil {
+				sc.fuzzy = newFuzzyIndex(settings.Fuzzy)
+			}
+			for k, entry := range catEntry.Keywords {
+				if entry.negatable || entry.negatedOnly {
+					sc.negation = negation
+				}
+				if entry.regex != nil {
+					sc.regexPatterns = append(sc.regexPatterns, len(sc.patterns))
+					keywords = append(keywords, "")
+					sc.patterns = append(sc.patterns, patternRef{group: g, category: c, keyword: k})
+					continue
+				}
+				if catEntry.Fuzzy {
+					sc.fuzzy.add(len(sc.patterns), entry.raw)
+				}
+				if catEntry.Phonetic {
+					phoneticKeys = append(phoneticKeys, entry.phonetic)
+					sc.phoneticPatterns = append(sc.phoneticPatterns, len(sc.patterns))
 				}
+				keywords = append(keywords, entry.raw)
+				sc.patterns = append(sc.patterns, patternRef{group: g, category: c, keyword: k})
+			}
+			for k, entry := range catEntry.Excludes {
+				if entry.regex != nil {
+					sc.regexPatterns = append(sc.regexPatterns, len(sc.patterns))
+					keywords = append(keywords, "")
+					sc.patterns = append(sc.patterns, patternRef{group: g, category: c, keyword: k, exclude: true})
+					continue
+				}
+				keywords = append(keywords, entry.raw)
+				sc.patterns = append(sc.patterns, patternRef{group: g, category: c, keyword: k, exclude: true})
 			}
 		}
+		group.endPattern = len(sc.patterns)
 	}
 
-	// Second: Find best partial match (phrase or substring)
-	var bestMatch *matchResult
+	sc.index = newAhoCorasick(keywords)
+	if len(phoneticKeys) > 0 {
+		sc.phonetic = newAhoCorasick(phoneticKeys)
+	}
+	sc.keywordHits = make([]atomic.Uint64, len(sc.patterns))
+	sc.keywordShadowed = make([]atomic.Uint64, len(sc.patterns))
+}
+
+// scan runs the stage automaton once over normalized text (plus the fuzzy index
+// and phonetic automaton when the stage has such categories) and returns one hit
+// per matched keyword, sorted by pattern id.
+// Occurrences of negatable keywords inside a negation scope are dropped, and
+// rerouted copies only count inside one. Categories whose exclude keywords occur
+// get no hits at all.
+func (sc *StageCategories) scan(normalized string) []keywordHit {
+	var hits []keywordHit
+	seen := make(map[int]int)
+	excluded := make(map[[2]int]bool) // {group, category} with an exclude keyword hit
+
+	// Negation scope is only computed when a negatable keyword occurs
+	var scope *negationScope
+	inScope := func(entry *keywordEntry, word func() int) bool {
+		if !entry.negatable && !entry.negatedOnly {
+			return true
+		}
+		if scope == nil {
+			scope = sc.negation.scope(normalized)
+		}
+		return scope.isNegated(word()) == entry.negatedOnly
+	}
+
+	literalHit := func(pattern, start, end, literal int) {
+		entry := sc.entryOf(keywordHit{pattern: pattern})
+
+		words := entry.words
+		if entry.regex != nil {
+			words = strings.Count(normalized[start:end], " ") + 1
+		}
+		exact, phrase, substring := spanKinds(normalized, start, end, words)
+		if !exact && !phrase && !substring {
+			return
+		}
+		if ref := sc.patterns[pattern]; ref.exclude {
+			excluded[[2]int{ref.group, ref.category}] = true
+			return
+		}
+		if !inScope(entry, func() int { return scope.wordAt(start) }) {
+			return
+		}
+
+		i, ok := seen[pattern]
+		if !ok {
+			i = len(hits)
+			seen[pattern] = i
+			hits = append(hits, keywordHit{pattern: pattern})
+		}
+		hits[i].exact = hits[i].exact || exact
+		hits[i].phrase = hits[i].phrase || phrase
+		hits[i].substring = hits[i].substring || substring
+		if entry.regex != nil && (literal > hits[i].literal ||
+			(literal == hits[i].literal && end-start > len(hits[i].span))) {
+			hits[i].span = normalized[start:end]
+			hits[i].literal = literal
+		}
+	}
+	sc.index.scan(normalized, func(pattern, start, end int) {
+		literalHit(pattern, start, end, end-start)
+	})
+
+	// Pattern keywords are matched by their own regex, with the same rules
+	for _, pattern := range sc.regexPatterns {
+		sc.entryOf(keywordHit{pattern: pattern}).findPattern(normalized, func(start, end, literal int) {
+			literalHit(pattern, start, end, literal)
+		})
+	}
 
-	for _, catEntry := range categories {
-		// Check phrase matches using tokenization
-		tokens := km.tokenize(normalized)
-		for _, entry := range catEntry.Keywords {
-			for _, token := range tokens {
-				if entry.raw == token {
-					if bestMatch == nil || len(token) > bestMatch.length {
-						bestMatch = &matchResult{
-							keyword:     token,
-							matchType:   "phrase",
-							length:      len(token),
-							category:    catEntry.Info.BaseName,
-							returnValue: catEntry.Info.ReturnValue,
-						}
+	// Fuzzy and phonetic hits only for keywords that did not match literally
+	literal := len(hits)
+	if sc.fuzzy != nil || sc.phonetic != nil {
+		words := strings.Fields(normalized)
+
+		if sc.fuzzy != nil {
+			sc.fuzzy.scan(words, func(pattern, start, distance int) {
+				if !inScope(sc.entryOf(keywordHit{pattern: pattern}), func() int { return start }) {
+					return
+				}
+				if i, ok := seen[pattern]; ok {
+					if i >= literal && distance < hits[i].distance {
+						hits[i].distance = distance
 					}
+					return
 				}
-			}
+				seen[pattern] = len(hits)
+				hits = append(hits, keywordHit{pattern: pattern, fuzzy: true, distance: distance})
+			})
 		}
 
-		// Check substring matches with precompiled regex (word boundaries)
-		for _, entry := range catEntry.Keywords {
-			if entry.regex.MatchString(normalized) {
-				if bestMatch == nil || len(entry.raw) > bestMatch.length {
-					bestMatch = &matchResult{
-						keyword:     entry.raw,
-						matchType:   "substring",
-						length:      len(entry.raw),
-						category:    catEntry.Info.BaseName,
-						returnValue: catEntry.Info.ReturnValue,
+		if sc.phonetic != nil {
+			key := phoneticKey(words)
+			sc.phonetic.scan(key, func(id, start, end int) {
+				if (start > 0 && key[start-1] != ' ') || (end < len(key) && key[end] != ' ') {
+					return
+				}
+				pattern := sc.phoneticPatterns[id]
+				if !inScope(sc.entryOf(keywordHit{pattern: pattern}), func() int { return strings.Count(key[:start], " ") }) {
+					return
+				}
+				if i, ok := seen[pattern]; ok {
+					if i >= literal {
+						hits[i].phonetic = true
 					}
+					return
 				}
+				seen[pattern] = len(hits)
+				hits = append(hits, keywordHit{pattern: pattern, phonetic: true})
+			})
+		}
+	}
+
+	// Drop every hit of a category disqualified by one of its exclude keywords
+	if len(excluded) > 0 {
+		kept := hits[:0]
+		for _, hit := range hits {
+			ref := sc.patterns[hit.pattern]
+			if !excluded[[2]int{ref.group, ref.category}] {
+				kept = append(kept, hit)
+			}
+		}
+		hits = kept
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].pattern < hits[j].pattern
+	})
+	return hits
+}
+
+// matchType returns the strongest way the hit matched the text
+func (hit keywordHit) matchType() string {
+	switch {
+	case hit.exact:
+		return "exact"
+	case hit.phrase:
+		return "phrase"
+	case hit.substring:
+		return "substring"
+	case hit.fuzzy:
+		return "fuzzy"
+	default:
+		return "phonetic"
+	}
+}
+
+// spanKinds reports how the text between start and end sits in normalized: as
+// the whole text, as a phrase of at most maxPhraseWords words between spaces,
+// or between word boundaries. A keyword occurrence that is none of them is no hit.
+func spanKinds(normalized string, start, end, words int) (exact, phrase, substring bool) {
+	exact = start == 0 && end == len(normalized)
+	phrase = words <= maxPhraseWords &&
+		(start == 0 || normalized[start-1] == ' ') &&
+		(end == len(normalized) || normalized[end] == ' ')
+	substring = isWordBoundary(normalized, start) && isWordBoundary(normalized, end)
+	return exact, phrase, substring
+}
+
+// groupHits returns the slice of hits that belong to group
+func (sc *StageCategories) groupHits(hits []keywordHit, group *categoryGroup) []keywordHit {
+	lo := sort.Search(len(hits), func(i int) bool { return hits[i].pattern >= group.firstPattern })
+	hi := sort.Search(len(hits), func(i int) bool { return hits[i].pattern >= group.endPattern })
+	return hits[lo:hi]
+}
+
+// findBestMatch finds the best keyword match among the hits of one category group
+// Matching priority: exact match > phrase match > substring match (with word boundaries) > fuzzy match > phonetic match
+// Ties are broken in a fixed order so identical requests always get the same result:
+//  1. the longest keyword wins (for pattern keywords, the text they matched less
+//     the words matched by a standalone "*", see rankLength)
+//  2. then the match type: phrase beats substring
+//  3. then the category written first in the campaign file (see KeyOrder)
+//  4. then the keyword listed first in its category
+//
+// Fuzzy hits are only considered when the group has no literal hit; among them the
+// longest keyword wins, then the smallest edit distance. Phonetic hits are only
+// considered when there is no fuzzy hit either; the longest keyword wins. Both
+// fall back to rules 3 and 4 on ties.
+func (km *KeywordMatcher) findBestMatch(normalized string, sc *StageCategories, hits []keywordHit) *matchResult {
+	if len(hits) == 0 {
+		return nil
+	}
+
+	// First: Check for exact matches across all categories
+	for _, hit := range hits {
+		if hit.exact {
+			return sc.newMatchResult(normalized, "exact", hit)
+		}
+	}
+
+	// Second: Find best partial match (phrase or substring)
+	// Hits are sorted by pattern id (category order, then keyword order), so only a
+	// strictly better hit replaces the current best
+	var bestMatch *matchResult
+
+	for _, hit := range hits {
+		matchType := "phrase"
+		if !hit.phrase {
+			if !hit.substring {
+				continue
 			}
+			matchType = "substring"
+		}
+		length := sc.rankLength(hit)
+		if bestMatch == nil || length > bestMatch.length ||
+			(length == bestMatch.length && matchType == "phrase" && bestMatch.matchType == "substring") {
+			bestMatch = sc.newMatchResult(sc.keywordOf(hit), matchType, hit)
+			bestMatch.length = length
+		}
+	}
+
+	if bestMatch != nil {
+		return bestMatch
+	}
+
+	// Third: Fuzzy matches (only reached when nothing matched literally)
+	var bestFuzzy *keywordHit
+	for i, hit := range hits {
+		if !hit.fuzzy {
+			continue
+		}
+		if bestFuzzy == nil {
+			bestFuzzy = &hits[i]
+			continue
+		}
+		length, bestLength := len(sc.entryOf(hit).raw), len(sc.entryOf(*bestFuzzy).raw)
+		if length > bestLength || (length == bestLength && hit.distance < bestFuzzy.distance) {
+			bestFuzzy = &hits[i]
+		}
+	}
+	if bestFuzzy != nil {
+		return sc.newMatchResult(sc.entryOf(*bestFuzzy).raw, "fuzzy", *bestFuzzy)
+	}
+
+	// Fourth: Phonetic matches
+	var bestPhonetic *keywordHit
+	for i, hit := range hits {
+		if hit.phonetic && (bestPhonetic == nil || len(sc.entryOf(hit).raw) > len(sc.entryOf(*bestPhonetic).raw)) {
+			bestPhonetic = &hits[i]
 		}
 	}
+	if bestPhonetic != nil {
+		return sc.newMatchResult(sc.entryOf(*bestPhonetic).raw, "phonetic", *bestPhonetic)
+	}
+
+	return nil
+}
+
+// newMatchResult builds a matchResult for keyword found by hit
+func (sc *StageCategories) newMatchResult(keyword, matchType string, hit keywordHit) *matchResult {
+	catEntry := sc.categoryOf(hit)
+	return &matchResult{
+		keyword:     keyword,
+		matchType:   matchType,
+		length:      len(keyword),
+		pattern:     hit.pattern,
+		category:    catEntry.Info.BaseName,
+		categoryKey: catEntry.Info.Key,
+		priority:    catEntry.Info.Priority,
+		hardcoded:   catEntry.Info.IsHardcoded,
+		returnValue: catEntry.Info.ReturnValue,
+	}
+}
+
+// categoryOf returns the category a hit belongs to
+func (sc *StageCategories) categoryOf(hit keywordHit) *CategoryEntry {
+	ref := sc.patterns[hit.pattern]
+	return &sc.groups[ref.group].categories[ref.category]
+}
+
+// keywordOf returns the keyword a hit matched: the keyword itself, or the text
+// matched by a pattern keyword
+func (sc *StageCategories) keywordOf(hit keywordHit) string {
+	if hit.span != "" {
+		return hit.span
+	}
+	return sc.entryOf(hit).raw
+}
+
+// rankLength is the length a hit ranks by in findBestMatch: that of the keyword,
+// or for a pattern keyword that of the text it matched less its wildcard words
+func (sc *StageCategories) rankLength(hit keywordHit) int {
+	if hit.span != "" {
+		return hit.literal
+	}
+	return len(sc.entryOf(hit).raw)
+}
 
-	return bestMatch
+// entryOf returns the keyword entry a hit belongs to
+func (sc *StageCategories) entryOf(hit keywordHit) *keywordEntry {
+	ref := sc.patterns[hit.pattern]
+	if ref.exclude {
+		return &sc.groups[ref.group].categories[ref.category].Excludes[ref.keyword]
+	}
+	return &sc.groups[ref.group].categories[ref.category].Keywords[ref.keyword]
 }
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestMain(m *testing.M) {
+	// Matchers log every loaded stage
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// loadTestCampaign reads a campaign of the keywords directory
+func loadTestCampaign(tb testing.TB, name string) *resolvedCampaign {
+	tb.Helper()
+	filePath := filepath.Join("keywords", name+".json")
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	resolved, err := resolveCampaign(filePath, data)
+	if err != nil {
+		tb.Fatalf("failed to parse %s: %v", filePath, err)
+	}
+	return resolved
+}
+
+// The automaton must return what the original per-keyword regex matcher
+// returned, wherever that matcher was deterministic: it grouped categories in
+// map order, so when categories sharing a priority level both matched, its
+// result changed between runs.
+func TestProcessStageMatchesBaseline(t *testing.T) {
+	resolved := loadTestCampaign(t, "fe_basic")
+
+	for _, scale := range []int{1, 3} {
+		t.Run(fmt.Sprintf("scale%d", scale), func(t *testing.T) {
+			raw := scaleKeywordSets(resolved.raw, scale)
+			km := NewKeywordMatcher(raw, resolved.order, "fe_basic.json")
+			keys := orderedKeys(raw, resolved.order[""])
+			forward := newBaselineMatcher(raw, keys)
+			reversed := newBaselineMatcher(raw, reverseStrings(keys))
+
+			corpus := syntheticCorpus(forward.keywordsByStage(), rand.New(rand.NewSource(1)), 200)
+			compared := 0
+			for _, u := range corpus {
+				want := forward.ProcessStage(u.text, u.stage)
+				if reversed.ProcessStage(u.text, u.stage) != want {
+					continue
+				}
+				compared++
+				if got := km.ProcessStage(u.text, u.stage); got != want {
+					t.Errorf("ProcessStage(%q, %s) = %q, baseline %q", u.text, u.stage, got, want)
+				}
+			}
+			if compared < len(corpus)/2 {
+				t.Fatalf("only %d of %d utterances have an order-independent baseline result", compared, len(corpus))
+			}
+		})
+	}
+}
+
+func BenchmarkProcessStage(b *testing.B)                { benchmarkProcessStage(b, 1, false) }
+func BenchmarkProcessStageBaseline(b *testing.B)        { benchmarkProcessStage(b, 1, true) }
+func BenchmarkProcessStageScale10(b *testing.B)         { benchmarkProcessStage(b, 10, false) }
+func BenchmarkProcessStageBaselineScale10(b *testing.B) { benchmarkProcessStage(b, 10, true) }
+
+// benchmarkProcessStage times ProcessStage, or the baseline matcher, on a
+// synthetic corpus of fe_basic with every keyword list multiplied by scale
+func benchmarkProcessStage(b *testing.B, scale int, baseline bool) {
+	resolved := loadTestCampaign(b, "fe_basic")
+	raw := scaleKeywordSets(resolved.raw, scale)
+	reference := newBaselineMatcher(raw, orderedKeys(raw, resolved.order[""]))
+	corpus := syntheticCorpus(reference.keywordsByStage(), rand.New(rand.NewSource(1)), 500)
+
+	process := NewKeywordMatcher(raw, resolved.order, "fe_basic.json").ProcessStage
+	if baseline {
+		process = reference.ProcessStage
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		u := corpus[i%len(corpus)]
+		process(u.text, u.stage)
+	}
+}
+
+type benchUtterance struct {
+	stage string
+	text  string
+}
+
+// benchFillerWords pads synthetic utterances around sampled keywords
+var benchFillerWords = []string{
+	"yeah", "so", "the", "we", "were", "just", "about", "to", "head", "out",
+	"and", "my", "wife", "said", "that", "maybe", "later", "today", "call", "again",
+}
+
+// syntheticCorpus builds perStage utterances for every stage: a mix of bare
+// keywords, keywords surrounded by filler, and filler-only text that hits nothing
+func syntheticCorpus(keywordsByStage map[string][]string, rng *rand.Rand, perStage int) []benchUtterance {
+	stages := make([]string, 0, len(keywordsByStage))
+	for stage := range keywordsByStage {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+
+	var corpus []benchUtterance
+	for _, stage := range stages {
+		keywords := keywordsByStage[stage]
+		if len(keywords) == 0 {
+			continue
+		}
+
+		for i := 0; i < perStage; i++ {
+			var words []string
+			for n := rng.Intn(12); n > 0; n-- {
+				words = append(words, benchFillerWords[rng.Intn(len(benchFillerWords))])
+			}
+			switch i % 4 {
+			case 0:
+				words = []string{keywords[rng.Intn(len(keywords))]}
+			case 1, 2:
+				pos := rng.Intn(len(words) + 1)
+				words = append(words[:pos], append([]string{keywords[rng.Intn(len(keywords))]}, words[pos:]...)...)
+			}
+			corpus = append(corpus, benchUtterance{stage: stage, text: strings.Join(words, " ")})
+		}
+	}
+	return corpus
+}
+
+// scaleKeywordSets returns a copy of rawKeywords where every category also holds
+// scale-1 synthetic variants of each keyword
+func scaleKeywordSets(rawKeywords FlexibleKeywordSets, scale int) FlexibleKeywordSets {
+	scaled := make(FlexibleKeywordSets, len(rawKeywords))
+	for key, value := range rawKeywords {
+		list, ok := value.([]interface{})
+		if !ok {
+			scaled[key] = value
+			continue
+		}
+		out := make([]interface{}, 0, len(list)*scale)
+		out = append(out, list...)
+		for i := 1; i < scale; i++ {
+			for _, item := range list {
+				if str, ok := item.(string); ok {
+					out = append(out, fmt.Sprintf("%s variant%d", str, i))
+				}
+			}
+		}
+		scaled[key] = out
+	}
+	return scaled
+}
+
+func reverseStrings(list []string) []string {
+	reversed := make([]string, len(list))
+	for i, s := range list {
+		reversed[len(list)-1-i] = s
+	}
+	return reversed
+}
+
+// baselineMatcher is a frozen copy of the original matcher: every keyword of a
+// category is scanned with its own regex and compared with the text's n-grams.
+// It only knows flat campaign files without settings. Do not update it along
+// with the matcher; it is the reference the automaton is checked against.
+type baselineMatcher struct {
+	stages map[string]*baselineStage
+}
+
+type baselineStage struct {
+	hardcoded   []baselineCategory
+	prioritized []baselineCategory // Sorted by priority, in the given key order within a level
+}
+
+type baselineCategory struct {
+	priority    int
+	returnValue string
+	keywords    []baselineKeyword
+}
+
+type baselineKeyword struct {
+	raw   string
+	regex *regexp.Regexp
+}
+
+var baselineContractions = map[string]string{
+	"i'm": "i am", "i've": "i have", "i'll": "i will", "i'd": "i would",
+	"can't": "cannot", "won't": "will not", "don't": "do not",
+	"doesn't": "does not", "didn't": "did not", "isn't": "is not",
+	"aren't": "are not", "wasn't": "was not", "weren't": "were not",
+	"hasn't": "has not", "haven't": "have not", "hadn't": "had not",
+	"wouldn't": "would not", "shouldn't": "should not", "couldn't": "could not",
+	"you're": "you are", "you've": "you have", "you'll": "you will", "you'd": "you would",
+	"he's": "he is", "she's": "she is", "it's": "it is", "that's": "that is",
+	"what's": "what is", "where's": "where is", "who's": "who is",
+	"there's": "there is", "we're": "we are", "we've": "we have",
+	"they're": "they are", "they've": "they have",
+}
+
+// newBaselineMatcher builds the baseline matcher, adding the categories in the
+// order of keys (the original matcher used map order)
+func newBaselineMatcher(rawKeywords FlexibleKeywordSets, keys []string) *baselineMatcher {
+	bm := &baselineMatcher{stages: make(map[string]*baselineStage)}
+	for _, key := range keys {
+		parts := strings.Split(key, "_")
+		if len(parts) < 3 || !strings.HasPrefix(parts[len(parts)-1], "s") {
+			continue
+		}
+		stage, priorityPart := parts[len(parts)-1], parts[len(parts)-2]
+		category := baselineCategory{returnValue: strings.ToLower(strings.Join(parts[:len(parts)-2], "_"))}
+		hardcoded := priorityPart == "hardcoded"
+		if !hardcoded {
+			if _, err := fmt.Sscanf(priorityPart, "p%d", &category.priority); err != nil {
+				continue
+			}
+		}
+
+		list, _ := rawKeywords[key].([]interface{})
+		for _, item := range list {
+			str, _ := item.(string)
+			if normalized := baselineNormalize(str); normalized != "" {
+				category.keywords = append(category.keywords, baselineKeyword{
+					raw:   normalized,
+					regex: regexp.MustCompile(`\b` + regexp.QuoteMeta(normalized) + `\b`),
+				})
+			}
+		}
+		if len(category.keywords) == 0 {
+			continue
+		}
+
+		if bm.stages[stage] == nil {
+			bm.stages[stage] = &baselineStage{}
+		}
+		if hardcoded {
+			bm.stages[stage].hardcoded = append(bm.stages[stage].hardcoded, category)
+		} else {
+			bm.stages[stage].prioritized = append(bm.stages[stage].prioritized, category)
+		}
+	}
+
+	for _, stageData := range bm.stages {
+		sort.SliceStable(stageData.prioritized, func(i, j int) bool {
+			return stageData.prioritized[i].priority < stageData.prioritized[j].priority
+		})
+	}
+	return bm
+}
+
+// keywordsByStage returns the normalized keywords of every stage
+func (bm *baselineMatcher) keywordsByStage() map[string][]string {
+	keywords := make(map[string][]string, len(bm.stages))
+	for stage, stageData := range bm.stages {
+		for _, categories := range [][]baselineCategory{stageData.hardcoded, stageData.prioritized} {
+			for _, category := range categories {
+				for _, kw := range category.keywords {
+					keywords[stage] = append(keywords[stage], kw.raw)
+				}
+			}
+		}
+	}
+	return keywords
+}
+
+func (bm *baselineMatcher) ProcessStage(text, stage string) string {
+	stageData, exists := bm.stages[stage]
+	if !exists {
+		return "unknown"
+	}
+	if result := baselineFindBestMatch(text, stageData.hardcoded); result != "" {
+		return result
+	}
+	for start := 0; start < len(stageData.prioritized); {
+		end := start + 1
+		for end < len(stageData.prioritized) && stageData.prioritized[end].priority == stageData.prioritized[start].priority {
+			end++
+		}
+		if result := baselineFindBestMatch(text, stageData.prioritized[start:end]); result != "" {
+			return result
+		}
+		start = end
+	}
+	return "unknown"
+}
+
+// baselineFindBestMatch returns the return value of the best match, "" if none:
+// an exact match, otherwise the longest phrase or substring match, where a later
+// match only wins when it is strictly longer
+func baselineFindBestMatch(text string, categories []baselineCategory) string {
+	normalized := baselineNormalize(text)
+
+	for _, category := range categories {
+		for _, kw := range category.keywords {
+			if kw.raw == normalized {
+				return category.returnValue
+			}
+		}
+	}
+
+	best, bestLength := "", -1
+	tokens := baselineTokenize(normalized)
+	for _, category := range categories {
+		for _, kw := range category.keywords {
+			for _, token := range tokens {
+				if kw.raw == token && len(token) > bestLength {
+					best, bestLength = category.returnValue, len(token)
+				}
+			}
+		}
+		for _, kw := range category.keywords {
+			if kw.regex.MatchString(normalized) && len(kw.raw) > bestLength {
+				best, bestLength = category.returnValue, len(kw.raw)
+			}
+		}
+	}
+	return best
+}
+
+func baselineNormalize(text string) string {
+	text = norm.NFKD.String(text)
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		return r
+	}, text)
+	text = strings.ToLower(strings.TrimSpace(text))
+
+	words := strings.Fields(text)
+	for i, word := range words {
+		if expansion, ok := baselineContractions[word]; ok {
+			words[i] = expansion
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// baselineTokenize returns the 1 to 5 word n-grams of text
+func baselineTokenize(text string) []string {
+	words := strings.Fields(text)
+	var tokens []string
+	for length := 1; length <= 5; length++ {
+		for i := 0; i+length <= len(words); i++ {
+			tokens = append(tokens, strings.Join(words[i:i+length], " "))
+		}
+	}
+	return tokens
+}
+
+// Categories sharing a priority level used to be checked in map order; every
+// reload of the same file must give the same result and keyword
+func TestFindBestMatchStableAcrossReloads(t *testing.T) {
+	data := []byte(`{
+		"zulu_p1_s1": ["ring me", "call me"],
+		"alpha_p1_s1": ["call me", "later"],
+		"substring_p2_s1": ["known fact"],
+		"phrase_p2_s1": ["well-known"]
+	}`)
+	tests := []struct {
+		text, result, keyword string
+	}{
+		// Same keyword in two categories of a level: the category written first
+		{"please call me", "zulu", "call me"},
+		// Same length in one category: the keyword listed first
+		{"call me or ring me", "zulu", "ring me"},
+		// The longest keyword still wins over file order
+		{"call me later this evening", "zulu", "call me"},
+		{"maybe later then", "alpha", "later"},
+		// Equal length: a phrase beats a substring, even from a later category
+		{"oh well-known fact", "phrase", "well-known"},
+	}
+
+	for reload := 0; reload < 50; reload++ {
+		raw, order, err := parseCampaignData(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		km := NewKeywordMatcher(raw, order, "ties.json")
+		for _, tt := range tests {
+			result := km.peekStage(tt.text, "s1")
+			if result == nil {
+				t.Fatalf("reload %d: %q matched nothing, want %s (%q)", reload, tt.text, tt.result, tt.keyword)
+			}
+			if result.returnValue != tt.result || result.keyword != tt.keyword {
+				t.Fatalf("reload %d: %q matched %s (%q), want %s (%q)",
+					reload, tt.text, result.returnValue, result.keyword, tt.result, tt.keyword)
+			}
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Metrics are kept in memory and rendered in the Prometheus text exposition
+// format by /metrics, so they can be scraped (or just curled) without any
+// Prometheus dependency.
+
+var (
+	matchResultsTotal = newCounterVec("keyword_matcher_match_results_total",
+		"Match results by campaign, stage and winning category (\"unknown\" when nothing matched).",
+		"campaign", "stage", "category", "result")
+	matchTypesTotal = newCounterVec("keyword_matcher_match_types_total",
+		"Matches by type of the winning hit (\"none\" when nothing matched).",
+		"campaign", "stage", "match_type")
+	matchDuration = newHistogramVec("keyword_matcher_process_stage_duration_seconds",
+		"Time spent matching one utterance.",
+		[]float64{0.000005, 0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.01},
+		"campaign")
+	inputLength = newHistogramVec("keyword_matcher_input_length_bytes",
+		"Length of the speech text of matched utterances.",
+		[]float64{8, 16, 32, 64, 128, 256, 512, 1024, 4096},
+		"campaign")
+	cacheRequestsTotal = newCounterVec("keyword_matcher_cache_requests_total",
+		"getMatcher lookups served from the cache (hit) or that had to load the campaign file (miss).",
+		"result")
+	reloadsTotal = newCounterVec("keyword_matcher_reloads_total",
+		"Campaign loads and reloads by outcome (success or failure).",
+		"campaign", "status")
+
+	allMetrics = []metricWriter{matchResultsTotal, matchTypesTotal, matchDuration, inputLength, cacheRequestsTotal, reloadsTotal}
+)
+
+type metricWriter interface {
+	writeTo(w io.Writer)
+}
+
+// counterVec is a counter partitioned by label values
+type counterVec struct {
+	sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64 // Rendered label set -> value
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// inc adds one to the counter with the given label values (in label order)
+func (cv *counterVec) inc(labelValues ...string) {
+	key := formatLabels(cv.labels, labelValues)
+	cv.Lock()
+	cv.values[key]++
+	cv.Unlock()
+}
+
+func (cv *counterVec) writeTo(w io.Writer) {
+	cv.Lock()
+	defer cv.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", cv.name, cv.help, cv.name)
+	for _, key := range sortedKeys(cv.values) {
+		fmt.Fprintf(w, "%s%s %s\n", cv.name, key, formatFloat(cv.values[key]))
+	}
+}
+
+// histogramVec is a histogram partitioned by label values
+type histogramVec struct {
+	sync.Mutex
+	name    string
+	help    string
+	labels  []string
+	buckets []float64 // Upper bounds, ascending; +Inf is implicit
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative; the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+}
+
+// observe records value for the given label values (in label order)
+func (hv *histogramVec) observe(value float64, labelValues ...string) {
+	key := formatLabels(hv.labels, labelValues)
+	bucket := sort.SearchFloat64s(hv.buckets, value)
+
+	hv.Lock()
+	defer hv.Unlock()
+
+	h, ok := hv.series[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(hv.buckets)+1)}
+		hv.series[key] = h
+	}
+	h.counts[bucket]++
+	h.sum += value
+	h.count++
+}
+
+func (hv *histogramVec) writeTo(w io.Writer) {
+	hv.Lock()
+	defer hv.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", hv.name, hv.help, hv.name)
+	keys := make([]string, 0, len(hv.series))
+	for key := range hv.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		h := hv.series[key]
+		var cumulative uint64
+		for i, count := range h.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(hv.buckets) {
+				le = formatFloat(hv.buckets[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, withLabel(key, "le", le), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", hv.name, key, formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", hv.name, key, h.count)
+	}
+}
+
+// formatLabels renders a label set as {name="value",...}
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelValueEscaper.Replace(value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// withLabel appends one more label to a rendered label set
+func withLabel(labels, name, value string) string {
+	label := name + `="` + value + `"`
+	if labels == "" {
+		return "{" + label + "}"
+	}
+	return labels[:len(labels)-1] + "," + label + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// otherStageLabel replaces stage names the campaign does not have in metric
+// labels, so requests cannot create unbounded series
+const otherStageLabel = "other"
+
+// observeMatch records the outcome of matching one utterance; result is nil
+// when nothing matched
+func observeMatch(matcher *KeywordMatcher, campaign, stage, text string, result *matchResult, elapsed time.Duration) {
+	category, returnValue, matchType := "unknown", "unknown", "none"
+	if result != nil {
+		category, returnValue, matchType = result.categoryKey, result.returnValue, result.matchType
+	}
+	if _, exists := matcher.stageMap[stage]; !exists {
+		stage = otherStageLabel
+	}
+
+	matchResultsTotal.inc(campaign, stage, category, returnValue)
+	matchTypesTotal.inc(campaign, stage, matchType)
+	matchDuration.observe(elapsed.Seconds(), campaign)
+	inputLength.observe(float64(len(text)), campaign)
+}
+
+// processStageObserved runs ProcessStage for a request, records its metrics and
+// appends it to the capture file; nil means "unknown"
+func processStageObserved(matcher *KeywordMatcher, campaign, text, stage string) *matchResult {
+	start := time.Now()
+	result := matcher.matchStage(text, stage)
+	observeMatch(matcher, campaign, stage, text, result, time.Since(start))
+	captureMatch(matcher, campaign, text, stage, result)
+	return result
+}
+
+func handleMetrics(c echo.Context) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	res.WriteHeader(http.StatusOK)
+	for _, m := range allMetrics {
+		m.writeTo(res)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Stages come from request input; only the campaign's own stages get a series
+func TestObserveMatchStageLabel(t *testing.T) {
+	km := newTestMatcher(t, `{"busy_p1_s1": ["busy"]}`)
+	observeMatch(km, "metrics_test", "s1", "busy", nil, time.Millisecond)
+	observeMatch(km, "metrics_test", "s1234567", "busy", nil, time.Millisecond)
+	observeMatch(km, "metrics_test", "sabc", "busy", nil, time.Millisecond)
+
+	var out strings.Builder
+	matchTypesTotal.writeTo(&out)
+	for _, want := range []string{
+		`{campaign="metrics_test",stage="s1",match_type="none"} 1`,
+		`{campaign="metrics_test",stage="other",match_type="none"} 2`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %s:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "s1234567") {
+		t.Errorf("unknown stage got its own series:\n%s", out.String())
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Negation actions for negatable categories
+const (
+	negationSuppress = "suppress" // Drop negated hits
+	negationReroute  = "reroute"  // Count negated hits for the opposite category instead
+)
+
+// negationDetector finds the words of a text that fall inside the scope of a negator
+type negationDetector struct {
+	negators [][]string // Normalized negators split into words ("do not" -> ["do", "not"])
+	window   int        // Number of words after a negator that are negated
+}
+
+// negationScope is the result of running the detector over one normalized text
+type negationScope struct {
+	wordStarts []int  // Byte offset of each word
+	negated    []bool // Word index -> preceded by a negator within the window
+}
+
+// newNegationDetector builds a detector from already normalized negators
+func newNegationDetector(negators []string, window int) *negationDetector {
+	nd := &negationDetector{window: window}
+	for _, negator := range negators {
+		if words := strings.Fields(negator); len(words) > 0 {
+			nd.negators = append(nd.negators, words)
+		}
+	}
+	return nd
+}
+
+// scope marks every word that follows a negator within the window
+func (nd *negationDetector) scope(normalized string) *negationScope {
+	words := strings.Fields(normalized)
+	ns := &negationScope{
+		wordStarts: make([]int, 0, len(words)),
+		negated:    make([]bool, len(words)),
+	}
+
+	offset := 0
+	for _, w := range words {
+		ns.wordStarts = append(ns.wordStarts, offset)
+		offset += len(w) + 1
+	}
+
+	for end := range words {
+		for _, negator := range nd.negators {
+			start := end - len(negator) + 1
+			if start < 0 || !equalWords(words[start:end+1], negator) {
+				continue
+			}
+			for i := end + 1; i <= end+nd.window && i < len(words); i++ {
+				ns.negated[i] = true
+			}
+		}
+	}
+
+	return ns
+}
+
+// wordAt returns the index of the word containing byte offset
+func (ns *negationScope) wordAt(offset int) int {
+	return sort.Search(len(ns.wordStarts), func(i int) bool { return ns.wordStarts[i] > offset }) - 1
+}
+
+// isNegated reports whether the word at index is inside a negation scope
+func (ns *negationScope) isNegated(word int) bool {
+	return word >= 0 && word < len(ns.negated) && ns.negated[word]
+}
+
+func equalWords(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// applyNegationRules marks the keywords of negatable categories and appends the
+// rerouted copies to their opposite categories. Must run before the stage
+// indexes are built.
+func (km *KeywordMatcher) applyNegationRules() {
+	categoryKeys := make([]string, 0, len(km.settings.Negation.Categories))
+	for categoryKey := range km.settings.Negation.Categories {
+		categoryKeys = append(categoryKeys, categoryKey)
+	}
+	sort.Strings(categoryKeys)
+
+	for _, categoryKey := range categoryKeys {
+		rule := km.settings.Negation.Categories[categoryKey]
+		rulePath := jsonPath(settingsKey, "negation", "categories", categoryKey)
+		source := km.findCategory(categoryKey)
+		if source == nil {
+			km.warnf(rulePath, "negation rule refers to unknown category: %s", categoryKey)
+			continue
+		}
+
+		// Optional subset of keywords; all keywords when empty
+		subset := make(map[string]bool)
+		for _, kw := range rule.Keywords {
+			subset[km.normalizeText(kw)] = true
+		}
+
+		var opposite *CategoryEntry
+		switch rule.Action {
+		case "", negationSuppress:
+		case negationReroute:
+			opposite = km.findCategory(rule.Opposite)
+			if opposite == nil || opposite.Info.Stage != source.Info.Stage {
+				km.warnf(rulePath, "negation rule for %s reroutes to unknown category %q in stage %s, suppressing instead",
+					categoryKey, rule.Opposite, source.Info.Stage)
+			}
+		default:
+			km.warnf(rulePath, "Unknown negation action %q for category %s, suppressing instead", rule.Action, categoryKey)
+		}
+
+		for i := range source.Keywords {
+			entry := &source.Keywords[i]
+			if entry.negatedOnly || (len(subset) > 0 && !subset[entry.raw]) {
+				continue
+			}
+			entry.negatable = true
+			if opposite != nil && opposite.Info.Stage == source.Info.Stage {
+				rerouted := *entry
+				rerouted.negatable = false
+				rerouted.negatedOnly = true
+				opposite.Keywords = append(opposite.Keywords, rerouted)
+			}
+		}
+	}
+}
+
+// findCategory returns the category with the given JSON key, or nil
+func (km *KeywordMatcher) findCategory(categoryKey string) *CategoryEntry {
+	for _, stageData := range km.stageMap {
+		for _, list := range [][]CategoryEntry{stageData.Hardcoded, stageData.Prioritized} {
+			for i := range list {
+				if list[i].Info.Key == categoryKey {
+					return &list[i]
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNegationDefaultNegators(t *testing.T) {
+	campaign := `{
+		"settings": {"negation": {%s"categories": {"interested_p1_s1": {}, "fine_p2_s1": {}}}},
+		"interested_p1_s1": ["interested"],
+		"fine_p2_s1": ["i am fine"]
+	}`
+
+	tests := []struct {
+		negators, text, result string
+	}{
+		// "no" answers the question; it does not negate what follows
+		{"", "no i am fine", "fine"},
+		{"", "no, i'm interested", "interested"},
+		{"", "i am not interested", "unknown"},
+		{"", "never interested", "unknown"},
+		// Campaigns can still list it
+		{`"negators": ["no"], `, "no i am fine", "unknown"},
+	}
+	for _, tt := range tests {
+		km := newTestMatcher(t, fmt.Sprintf(campaign, tt.negators))
+		if got := returnValueOf(km.peekStage(tt.text, "s1")); got != tt.result {
+			t.Errorf("%q (negators %s) = %s, want %s", tt.text, tt.negators, got, tt.result)
+		}
+	}
+}
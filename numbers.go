@@ -0,0 +1,270 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Spoken number words understood by canonicalizeNumbers
+var (
+	numberUnits = map[string]int{
+		"zero": 0, "oh": 0, "one": 1, "two": 2, "three": 3, "four": 4,
+		"five": 5, "six": 6, "seven": 7, "eight": 8, "nine": 9,
+	}
+	numberTeens = map[string]int{
+		"ten": 10, "eleven": 11, "twelve": 12, "thirteen": 13, "fourteen": 14,
+		"fifteen": 15, "sixteen": 16, "seventeen": 17, "eighteen": 18, "nineteen": 19,
+	}
+	numberTens = map[string]int{
+		"twenty": 20, "thirty": 30, "forty": 40, "fifty": 50,
+		"sixty": 60, "seventy": 70, "eighty": 80, "ninety": 90,
+	}
+	numberScales = map[string]int{
+		"thousand": 1000, "million": 1000000,
+	}
+	// Words before which a lone "one" is a pronoun ("no one", "this one")
+	oneDeterminers = map[string]bool{
+		"no": true, "this": true, "that": true, "the": true, "which": true, "any": true,
+		"every": true, "each": true, "some": true, "other": true, "another": true,
+		"little": true, "big": true, "right": true, "wrong": true,
+	}
+)
+
+// digitTokenRegex matches digit strings, optionally grouped with commas or dashes
+// e.g. "65", "1,000", "555-1234"
+var digitTokenRegex = regexp.MustCompile(`^\d+([,-]\d+)*$`)
+
+// numberKind is the last element read by parseNumberRun
+type numberKind int
+
+const (
+	numberNone numberKind = iota
+	numberUnit
+	numberTeen
+	numberTen
+	numberHundred
+	numberScale
+)
+
+// canonicalizeNumbers rewrites spoken numbers and digit strings as plain digits
+//   - "sixty five" -> "65", "one hundred and five" -> "105", "twenty-one" -> "21"
+//   - "1,000" -> "1000", "555-1234" -> "5551234"
+//   - consecutive spoken numbers of one or two digits are read as a digit
+//     sequence (phone numbers, years): "five five five one two three four" ->
+//     "5551234", "oh five" -> "05", "nineteen eighty four" -> "1984"
+//   - other consecutive numbers stay apart: "5 6" -> "5 6",
+//     "one hundred five five" -> "105 5"
+//
+// "oh" is only a digit next to another number and "a" only before "hundred",
+// "thousand" or "million", so "oh no" and "a lot" are left alone. A lone "one"
+// after a determiner or before "of" is a pronoun: "no one", "this one" and
+// "one of them" are left alone.
+func canonicalizeNumbers(words []string) []string {
+	// Split hyphenated spoken numbers ("twenty-one")
+	split := make([]string, 0, len(words))
+	for _, word := range words {
+		if parts := strings.Split(word, "-"); len(parts) > 1 && allSpokenNumbers(parts) {
+			split = append(split, parts...)
+			continue
+		}
+		split = append(split, word)
+	}
+	words = split
+
+	out := make([]string, 0, len(words))
+	for i := 0; i < len(words); {
+		if !startsNumber(words, i) {
+			out = append(out, words[i])
+			i++
+			continue
+		}
+
+		j := i + 1
+		for j < len(words) && (isNumberWord(words[j]) || continuesNumber(words, j)) {
+			j++
+		}
+		if j == i+1 && isPronounOne(words, i) {
+			out = append(out, words[i])
+		} else {
+			out = append(out, parseNumberRun(words[i:j])...)
+		}
+		i = j
+	}
+	return out
+}
+
+// allSpokenNumbers reports whether every part is a spoken number word
+func allSpokenNumbers(parts []string) bool {
+	for _, part := range parts {
+		if !isNumberWord(part) || digitTokenRegex.MatchString(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// isNumberWord reports whether word is a digit string or a spoken number word
+func isNumberWord(word string) bool {
+	if _, ok := numberUnits[word]; ok {
+		return true
+	}
+	if _, ok := numberTeens[word]; ok {
+		return true
+	}
+	if _, ok := numberTens[word]; ok {
+		return true
+	}
+	if _, ok := numberScales[word]; ok {
+		return true
+	}
+	return word == "hundred" || digitTokenRegex.MatchString(word)
+}
+
+// startsNumber reports whether words[i] starts a number
+func startsNumber(words []string, i int) bool {
+	switch words[i] {
+	case "oh":
+		// Only before another digit ("oh five", "oh oh seven")
+		for j := i + 1; j < len(words); j++ {
+			if words[j] != "oh" {
+				return isNumberWord(words[j])
+			}
+		}
+		return false
+	case "a":
+		if i+1 < len(words) {
+			_, scale := numberScales[words[i+1]]
+			return scale || words[i+1] == "hundred"
+		}
+		return false
+	}
+	return isNumberWord(words[i])
+}
+
+// isPronounOne reports whether the "one" at words[i] stands for a thing rather
+// than a number ("no one", "this one", "one of them")
+func isPronounOne(words []string, i int) bool {
+	if words[i] != "one" {
+		return false
+	}
+	return (i > 0 && oneDeterminers[words[i-1]]) || (i+1 < len(words) && words[i+1] == "of")
+}
+
+// continuesNumber reports whether the "and" at words[i] joins two parts of the
+// same number ("one hundred and five")
+func continuesNumber(words []string, i int) bool {
+	if words[i] != "and" || i == 0 || i+1 >= len(words) {
+		return false
+	}
+	_, afterScale := numberScales[words[i-1]]
+	if !afterScale && words[i-1] != "hundred" {
+		return false
+	}
+	next := words[i+1]
+	_, nextScale := numberScales[next]
+	return isNumberWord(next) && !nextScale && next != "hundred" && !digitTokenRegex.MatchString(next)
+}
+
+// parseNumberRun converts a run of number words to digits
+// The run is split into numbers wherever a word cannot extend the current one
+// ("twenty" can take "one", "five" cannot take "six"). Spoken numbers of one or
+// two digits next to each other are concatenated; every other number is a word
+// of its own.
+func parseNumberRun(words []string) []string {
+	var numbers []string
+	var total, current, lastScale int
+	kind := numberNone
+	joinable := true // The current number is spoken and has one or two digits
+	lastJoinable := false
+
+	// add appends a number, concatenated to the previous one if both are joinable
+	add := func(digits string, canJoin bool) {
+		if canJoin && lastJoinable {
+			numbers[len(numbers)-1] += digits
+		} else {
+			numbers = append(numbers, digits)
+		}
+		lastJoinable = canJoin
+	}
+	flush := func() {
+		if kind != numberNone {
+			add(strconv.Itoa(total+current), joinable)
+		}
+		total, current, lastScale = 0, 0, 0
+		kind = numberNone
+		joinable = true
+	}
+
+	for _, word := range words {
+		if word == "and" {
+			continue
+		}
+		if digitTokenRegex.MatchString(word) {
+			flush()
+			add(strings.NewReplacer(",", "", "-", "").Replace(word), false)
+			continue
+		}
+
+		if word == "a" {
+			flush()
+			current, kind = 1, numberUnit
+			joinable = false
+			continue
+		}
+		if value, ok := numberUnits[word]; ok {
+			if (kind == numberTen && current%10 == 0) || kind == numberHundred || kind == numberScale {
+				current += value
+			} else {
+				flush()
+				current = value
+			}
+			kind = numberUnit
+			continue
+		}
+		if value, ok := numberTeens[word]; ok {
+			if kind == numberHundred || kind == numberScale {
+				current += value
+			} else {
+				flush()
+				current = value
+			}
+			kind = numberTeen
+			continue
+		}
+		if value, ok := numberTens[word]; ok {
+			if kind == numberHundred || kind == numberScale {
+				current += value
+			} else {
+				flush()
+				current = value
+			}
+			kind = numberTen
+			continue
+		}
+		if word == "hundred" {
+			if (kind == numberUnit || kind == numberTeen || kind == numberTen) && current > 0 && current < 100 {
+				current *= 100
+			} else {
+				flush()
+				current = 100
+			}
+			kind = numberHundred
+			joinable = false
+			continue
+		}
+		if scale, ok := numberScales[word]; ok {
+			if kind != numberNone && kind != numberScale && current > 0 && (lastScale == 0 || scale < lastScale) {
+				total += current * scale
+				current = 0
+			} else {
+				flush()
+				total = scale
+			}
+			kind, lastScale = numberScale, scale
+			joinable = false
+		}
+	}
+	flush()
+	return numbers
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanonicalizeNumbers(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"over sixty five", "over 65"},
+		{"one hundred and five dollars", "105 dollars"},
+		{"twenty-one", "21"},
+		{"1,000 or 555-1234", "1000 or 5551234"},
+		{"a hundred", "100"},
+		{"two thousand and twenty", "2020"},
+		// Spoken digit sequences and years
+		{"five five five one two three four", "5551234"},
+		{"oh five", "05"},
+		{"nineteen eighty four", "1984"},
+		// Separate numbers stay apart
+		{"5 6", "5 6"},
+		{"press 1 2 times", "press 1 2 times"},
+		{"two hundred twenty twenty", "220 20"},
+		{"one hundred five five", "105 5"},
+		// "one" as a pronoun, "oh" and "a" as words
+		{"no one is home", "no one is home"},
+		{"this one is fine", "this one is fine"},
+		{"one of them", "one of them"},
+		{"press one", "press 1"},
+		{"one one two", "112"},
+		{"oh no", "oh no"},
+		{"a lot", "a lot"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(canonicalizeNumbers(strings.Fields(tt.text)), " "); got != tt.want {
+			t.Errorf("canonicalizeNumbers(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// Keywords and speech are canonicalized alike, so numbers match however they are said
+func TestNumberKeywords(t *testing.T) {
+	km := newTestMatcher(t, `{
+		"settings": {"numbers": {"enabled": true}},
+		"senior_p1_s1": ["over sixty five"],
+		"someone_p2_s1": ["no one"],
+		"press_p3_s1": ["press 5"]
+	}`)
+
+	tests := []struct {
+		text, result string
+	}{
+		{"i am over 65", "senior"},
+		{"there is no one here", "someone"},
+		{"press five", "press"},
+		{"press 5 6", "press"},
+		{"press 56", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := returnValueOf(km.peekStage(tt.text, "s1")); got != tt.result {
+			t.Errorf("%q = %s, want %s", tt.text, got, tt.result)
+		}
+	}
+}
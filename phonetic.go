@@ -0,0 +1,173 @@
+package main
+
+import (
+	"strings"
+)
+
+// metaphone returns the Metaphone encoding of a single word.
+// Sound-alike words share a code, e.g. "no"/"know" -> "N", "right"/"write" -> "RT",
+// "not"/"knot" -> "NT". Words without letters are returned unchanged so they
+// can still only match themselves.
+func metaphone(word string) string {
+	// Keep ASCII letters only (apostrophes, digits and accents are dropped)
+	letters := make([]byte, 0, len(word))
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		if 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		if 'A' <= c && c <= 'Z' {
+			// Drop duplicate adjacent letters except C
+			if len(letters) > 0 && letters[len(letters)-1] == c && c != 'C' {
+				continue
+			}
+			letters = append(letters, c)
+		}
+	}
+	if len(letters) == 0 {
+		return word
+	}
+
+	// Initial letter exceptions
+	w := string(letters)
+	switch {
+	case strings.HasPrefix(w, "KN"), strings.HasPrefix(w, "GN"), strings.HasPrefix(w, "PN"),
+		strings.HasPrefix(w, "AE"), strings.HasPrefix(w, "WR"):
+		w = w[1:]
+	case strings.HasPrefix(w, "X"):
+		w = "S" + w[1:]
+	case strings.HasPrefix(w, "WH"):
+		w = "W" + w[2:]
+	}
+
+	at := func(i int) byte {
+		if i < 0 || i >= len(w) {
+			return 0
+		}
+		return w[i]
+	}
+
+	var code strings.Builder
+	for i := 0; i < len(w); i++ {
+		c := w[i]
+		prev, next, after := at(i-1), at(i+1), at(i+2)
+
+		switch c {
+		case 'A', 'E', 'I', 'O', 'U':
+			if i == 0 {
+				code.WriteByte(c)
+			}
+		case 'B':
+			// Silent in a final "MB"
+			if !(prev == 'M' && i == len(w)-1) {
+				code.WriteByte('B')
+			}
+		case 'C':
+			switch {
+			case next == 'I' && after == 'A':
+				code.WriteByte('X')
+			case next == 'H':
+				if prev == 'S' {
+					code.WriteByte('K')
+				} else {
+					code.WriteByte('X')
+				}
+			case next == 'I' || next == 'E' || next == 'Y':
+				if prev != 'S' {
+					code.WriteByte('S')
+				}
+			default:
+				code.WriteByte('K')
+			}
+		case 'D':
+			if next == 'G' && (after == 'E' || after == 'Y' || after == 'I') {
+				code.WriteByte('J')
+			} else {
+				code.WriteByte('T')
+			}
+		case 'G':
+			switch {
+			case next == 'H' && i+2 < len(w) && !isVowelByte(after):
+				// Silent in "GH" not at the end and not before a vowel ("right")
+			case next == 'N' && (i+2 == len(w) || (after == 'E' && at(i+3) == 'D' && i+4 == len(w))):
+				// Silent in a final "GN" or "GNED" ("sign", "signed")
+			case (next == 'I' || next == 'E' || next == 'Y') && prev != 'G':
+				code.WriteByte('J')
+			default:
+				code.WriteByte('K')
+			}
+		case 'H':
+			afterVowel := isVowelByte(prev) && !isVowelByte(next)
+			afterModifier := prev == 'C' || prev == 'S' || prev == 'P' || prev == 'T' || prev == 'G'
+			if !afterVowel && !afterModifier {
+				code.WriteByte('H')
+			}
+		case 'K':
+			if prev != 'C' {
+				code.WriteByte('K')
+			}
+		case 'P':
+			if next == 'H' {
+				code.WriteByte('F')
+			} else {
+				code.WriteByte('P')
+			}
+		case 'Q':
+			code.WriteByte('K')
+		case 'S':
+			switch {
+			case next == 'H':
+				code.WriteByte('X')
+			case next == 'I' && (after == 'O' || after == 'A'):
+				code.WriteByte('X')
+			default:
+				code.WriteByte('S')
+			}
+		case 'T':
+			switch {
+			case next == 'I' && (after == 'O' || after == 'A'):
+				code.WriteByte('X')
+			case next == 'H':
+				code.WriteByte('0')
+			case next == 'C' && after == 'H':
+				// Silent in "TCH"
+			default:
+				code.WriteByte('T')
+			}
+		case 'V':
+			code.WriteByte('F')
+		case 'W', 'Y':
+			if isVowelByte(next) {
+				code.WriteByte(c)
+			}
+		case 'X':
+			code.WriteString("KS")
+		case 'Z':
+			code.WriteByte('S')
+		default:
+			// F, J, L, M, N, R
+			code.WriteByte(c)
+		}
+	}
+
+	if code.Len() == 0 {
+		// Words made only of silent letters keep their first letter
+		return w[:1]
+	}
+	return code.String()
+}
+
+func isVowelByte(c byte) bool {
+	return c == 'A' || c == 'E' || c == 'I' || c == 'O' || c == 'U'
+}
+
+// phoneticKey encodes every word of a normalized text and joins the codes with
+// single spaces, so phonetic keywords can be found with the same word-aligned
+// automaton scan as literal ones
+func phoneticKey(words []string) string {
+	codes := make([]string, len(words))
+	for i, w := range words {
+		codes[i] = metaphone(w)
+	}
+	return strings.Join(codes, " ")
+}
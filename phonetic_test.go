@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestMetaphone(t *testing.T) {
+	tests := []struct {
+		words []string
+		same  bool
+	}{
+		{[]string{"no", "know"}, true},
+		{[]string{"right", "write"}, true},
+		{[]string{"not", "knot"}, true},
+		{[]string{"night", "knight"}, true},
+		{[]string{"busy", "bossy"}, true},
+		{[]string{"right", "wrong"}, false},
+		{[]string{"not", "note"}, true},
+		{[]string{"call", "tall"}, false},
+	}
+	for _, tt := range tests {
+		a, b := metaphone(tt.words[0]), metaphone(tt.words[1])
+		if (a == b) != tt.same {
+			t.Errorf("metaphone(%q) = %s, metaphone(%q) = %s; want same code: %v", tt.words[0], a, tt.words[1], b, tt.same)
+		}
+	}
+	if got := metaphone("123"); got != "123" {
+		t.Errorf("metaphone of a word without letters = %q, want it unchanged", got)
+	}
+}
+
+func TestPhoneticMatching(t *testing.T) {
+	km := newTestMatcher(t, `{
+		"settings": {"match_modes": {"right_p1_s1": ["phonetic"], "notInterested_p2_s1": ["phonetic"]}},
+		"right_p1_s1": ["that is right"],
+		"busy_p1_s1": ["busy"],
+		"notInterested_p2_s1": ["not interested"],
+		"callback_p3_s1": ["call me back"]
+	}`)
+
+	checkStageMatches(t, km, "s1", []stageMatchCase{
+		// Sound-alike words
+		{"that is write", "right", "phonetic"},
+		{"i am knot interested", "notinterested", "phonetic"},
+		// Different sounds, and categories without the mode
+		{"that is wrong", "unknown", ""},
+		{"i am not interesting", "unknown", ""},
+		{"kall me back", "unknown", ""},
+		// Literal matches come first: spelled right, and in the same priority level
+		{"that is right", "right", "exact"},
+		{"that is write but busy", "busy", "phrase"},
+		// A phonetic hit still wins over literal hits of lower priority levels
+		{"that is write call me back", "right", "phonetic"},
+	})
+}
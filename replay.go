@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// runReplay implements the "replay" subcommand.
+// Every record of one or more capture files (see CaptureRecord) is matched again
+// against the campaigns of the keywords directory, and every record whose result
+// changed is reported. Exits non-zero when any result changed, so the blast
+// radius of a keyword edit can be checked before it is deployed.
+//
+//	./main replay requests.jsonl requests.jsonl.1
+//	./main replay -keywords /path/to/edited/keywords requests.jsonl
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	keywordsDir := fs.String("keywords", "keywords", "keywords directory to replay against")
+	limit := fs.Int("limit", 100, "maximum number of changed records to print (0 = all)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "replay: no capture files given")
+		return 2
+	}
+
+	// Loading logs every warning; replay only reports results
+	log.SetOutput(io.Discard)
+
+	type campaignStats struct {
+		total   int
+		changed int
+	}
+	stats := make(map[string]*campaignStats)
+	matchers := make(map[string]*KeywordMatcher)
+	loadErrors := make(map[string]error)
+
+	printed := 0
+	for _, file := range fs.Args() {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+			return 2
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			var record CaptureRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.Campaign == "" {
+				fmt.Fprintf(os.Stderr, "%s:%d: skipping invalid record\n", file, line)
+				continue
+			}
+
+			matcher, ok := matchers[record.Campaign]
+			if !ok && loadErrors[record.Campaign] == nil {
+				matcher, err = loadKeywordMatcher(filepath.Join(*keywordsDir, record.Campaign+".json"))
+				if err != nil {
+					loadErrors[record.Campaign] = err
+				} else {
+					matchers[record.Campaign] = matcher
+				}
+			}
+
+			s, ok := stats[record.Campaign]
+			if !ok {
+				s = &campaignStats{}
+				stats[record.Campaign] = s
+			}
+			s.total++
+
+			result, keyword := "unknown", ""
+			if matcher != nil {
+				if m := record.replay(matcher); m != nil {
+					result, keyword = m.returnValue, m.keyword
+				}
+			}
+			if result == record.Result {
+				continue
+			}
+
+			s.changed++
+			if *limit == 0 || printed < *limit {
+				printed++
+				fmt.Printf("%s:%d: %s %s %q: %s (%q) -> %s (%q)\n", file, line, record.Campaign, record.Stage,
+					record.SpeechText, record.Result, record.Keyword, result, keyword)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "replay: %s: %v\n", file, err)
+			return 2
+		}
+	}
+
+	campaigns := make([]string, 0, len(stats))
+	for campaign := range stats {
+		campaigns = append(campaigns, campaign)
+	}
+	sort.Strings(campaigns)
+
+	changed := 0
+	for _, campaign := range campaigns {
+		s := stats[campaign]
+		changed += s.changed
+		note := ""
+		if err := loadErrors[campaign]; err != nil {
+			note = fmt.Sprintf(" (campaign failed to load: %v)", err)
+		}
+		fmt.Printf("%-24s %6d replayed %6d changed%s\n", campaign, s.total, s.changed, note)
+	}
+
+	if changed > 0 {
+		fmt.Fprintf(os.Stderr, "replay: %d result(s) changed\n", changed)
+		return 1
+	}
+	return 0
+}
+
+// replay matches a captured request again; requests with alternatives go
+// through the same policy decision as /match
+func (record *CaptureRecord) replay(matcher *KeywordMatcher) *matchResult {
+	if len(record.Alternatives) == 0 {
+		return matcher.matchStage(record.SpeechText, record.Stage)
+	}
+	req := MatchRequest{
+		Campaign:          record.Campaign,
+		SpeechText:        record.SpeechText,
+		Stage:             record.Stage,
+		Alternatives:      record.Alternatives,
+		Policy:            record.Policy,
+		MinWordConfidence: record.MinWordConfidence,
+	}
+	if validateAlternatives(&req) != "" {
+		return nil
+	}
+	return matcher.matchAlternatives(&req).result
+}
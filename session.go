@@ -0,0 +1,370 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// transitionAny is the result key of a transition that applies to any result
+const transitionAny = "*"
+
+// defaultSessionTTL is how long an idle session is kept (SESSION_TTL overrides it)
+const defaultSessionTTL = 30 * time.Minute
+
+// defaultMaxSessions is how many live sessions the store holds (MAX_SESSIONS overrides it)
+const defaultMaxSessions = 10000
+
+// maxSessionTurns is how many turns a session keeps; older turns are dropped
+// and only counted in TurnCount
+const maxSessionTurns = 50
+
+var (
+	errSessionExists   = errors.New("session already exists")
+	errTooManySessions = errors.New("too many sessions")
+)
+
+// Session tracks one call as it moves through the stages of a campaign
+type Session struct {
+	CallID       string        `json:"call_id"`
+	Campaign     string        `json:"campaign"`
+	CurrentStage string        `json:"current_stage"`
+	Turns        []SessionTurn `json:"turns"`      // The last maxSessionTurns turns
+	TurnCount    int           `json:"turn_count"` // Turns recorded, including dropped ones
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+	ExpiresAt    time.Time     `json:"expires_at"`
+}
+
+// SessionTurn is one utterance matched within a session
+type SessionTurn struct {
+	Stage       string            `json:"stage"`
+	SpeechText  string            `json:"speech_text"`
+	Result      string            `json:"result"`
+	NextStage   string            `json:"next_stage,omitempty"`
+	Explanation *MatchExplanation `json:"explanation"`
+	MatchedAt   time.Time         `json:"matched_at"`
+}
+
+type CreateSessionRequest struct {
+	CallID   string `json:"call_id"`
+	Campaign string `json:"campaign"`
+	Stage    string `json:"stage"` // First stage of the call (default: s1)
+}
+
+type SessionMatchRequest struct {
+	SpeechText string `json:"speech_text" form:"speech_text" query:"speech_text"`
+	Stage      string `json:"stage" form:"stage" query:"stage"` // Default: the session's current stage
+}
+
+type SessionMatchResponse struct {
+	CallID      string            `json:"call_id"`
+	Result      string            `json:"result"`
+	Stage       string            `json:"stage"`
+	Campaign    string            `json:"campaign"`
+	NextStage   string            `json:"next_stage,omitempty"` // Suggested by the campaign transitions
+	Explanation *MatchExplanation `json:"explanation"`
+}
+
+// SessionStore keeps up to maxSessions sessions in memory; a session expires
+// once it has been idle for longer than the TTL
+type SessionStore struct {
+	sync.Mutex
+	sessions    map[string]*Session
+	ttl         time.Duration
+	maxSessions int
+}
+
+var sessionStore *SessionStore
+
+func NewSessionStore(ttl time.Duration, maxSessions int) *SessionStore {
+	return &SessionStore{
+		sessions:    make(map[string]*Session),
+		ttl:         ttl,
+		maxSessions: maxSessions,
+	}
+}
+
+// ExpireSessions removes expired sessions every interval
+func (ss *SessionStore) ExpireSessions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		ss.Lock()
+		expired := ss.expire(now)
+		ss.Unlock()
+
+		if expired > 0 {
+			log.Printf("Expired %d idle session(s)", expired)
+		}
+	}
+}
+
+// expire removes the sessions expired at now and returns how many it removed.
+// Caller holds the lock.
+func (ss *SessionStore) expire(now time.Time) int {
+	expired := 0
+	for callID, session := range ss.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(ss.sessions, callID)
+			expired++
+		}
+	}
+	return expired
+}
+
+// create starts a session. It fails with errSessionExists if the call ID already
+// has a live session, and with errTooManySessions if the store is full of live ones.
+func (ss *SessionStore) create(callID, campaign, stage string) (Session, error) {
+	ss.Lock()
+	defer ss.Unlock()
+
+	now := time.Now()
+	if existing, ok := ss.sessions[callID]; ok && now.Before(existing.ExpiresAt) {
+		return existing.snapshot(), errSessionExists
+	}
+	// Expired sessions still count until the next sweep, so sweep before refusing
+	if len(ss.sessions) >= ss.maxSessions && ss.expire(now) == 0 {
+		return Session{}, errTooManySessions
+	}
+
+	session := &Session{
+		CallID:       callID,
+		Campaign:     campaign,
+		CurrentStage: stage,
+		Turns:        make([]SessionTurn, 0),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		ExpiresAt:    now.Add(ss.ttl),
+	}
+	ss.sessions[callID] = session
+	return session.snapshot(), nil
+}
+
+// get returns a copy of a live session
+func (ss *SessionStore) get(callID string) (Session, bool) {
+	ss.Lock()
+	defer ss.Unlock()
+
+	session, ok := ss.lookup(callID)
+	if !ok {
+		return Session{}, false
+	}
+	return session.snapshot(), true
+}
+
+// record appends a turn, moves the session to the turn's next stage (if any) and
+// extends its expiry
+func (ss *SessionStore) record(callID string, turn SessionTurn) (Session, bool) {
+	ss.Lock()
+	defer ss.Unlock()
+
+	session, ok := ss.lookup(callID)
+	if !ok {
+		return Session{}, false
+	}
+
+	session.Turns = append(session.Turns, turn)
+	if drop := len(session.Turns) - maxSessionTurns; drop > 0 {
+		session.Turns = append(session.Turns[:0], session.Turns[drop:]...)
+	}
+	session.TurnCount++
+	if turn.NextStage != "" {
+		session.CurrentStage = turn.NextStage
+	}
+	session.UpdatedAt = turn.MatchedAt
+	session.ExpiresAt = turn.MatchedAt.Add(ss.ttl)
+	return session.snapshot(), true
+}
+
+// lookup returns a live session; expired sessions are removed. Caller holds the lock.
+func (ss *SessionStore) lookup(callID string) (*Session, bool) {
+	session, ok := ss.sessions[callID]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(session.ExpiresAt) {
+		delete(ss.sessions, callID)
+		return nil, false
+	}
+	return session, true
+}
+
+// snapshot copies a session so it can be encoded without holding the store lock
+func (s *Session) snapshot() Session {
+	out := *s
+	out.Turns = append(make([]SessionTurn, 0, len(s.Turns)), s.Turns...)
+	return out
+}
+
+// nextStage returns the stage suggested after result in stage, or "" if the
+// campaign declares no transition for it
+func (km *KeywordMatcher) nextStage(stage, result string) string {
+	transitions := km.settings.Transitions[stage]
+	if next, ok := transitions[result]; ok {
+		return next
+	}
+	return transitions[transitionAny]
+}
+
+// checkTransitions warns about transitions that can never apply: unknown source
+// stages and results that no category of the stage returns
+func (km *KeywordMatcher) checkTransitions(order KeyOrder) {
+	stagesPath := jsonPath(settingsKey, "transitions")
+	stages := make(map[string]interface{}, len(km.settings.Transitions))
+	for stage := range km.settings.Transitions {
+		stages[stage] = nil
+	}
+
+	for _, stage := range orderedKeys(stages, order[stagesPath]) {
+		stageData, exists := km.stageMap[stage]
+		if !exists {
+			km.warnf(jsonPath(stagesPath, stage), "transitions refer to unknown stage: %s", stage)
+			continue
+		}
+
+		results := map[string]bool{"unknown": true, transitionAny: true}
+		for _, list := range [][]CategoryEntry{stageData.Hardcoded, stageData.Prioritized} {
+			for _, catEntry := range list {
+				results[catEntry.Info.ReturnValue] = true
+			}
+		}
+
+		transitions := make(map[string]interface{}, len(km.settings.Transitions[stage]))
+		for result := range km.settings.Transitions[stage] {
+			transitions[result] = nil
+		}
+		for _, result := range orderedKeys(transitions, order[jsonPath(stagesPath, stage)]) {
+			if !results[result] {
+				km.warnf(jsonPath(stagesPath, stage, result), "transition from %s refers to unknown result: %s", stage, result)
+			}
+		}
+	}
+}
+
+func handleCreateSession(c echo.Context) error {
+	var req CreateSessionRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	if req.CallID == "" || req.Campaign == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "call_id and campaign are required"})
+	}
+	if req.Stage == "" {
+		req.Stage = "s1"
+	}
+	if !strings.HasPrefix(req.Stage, "s") {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid stage format. Must be s1, s2, s3, etc."})
+	}
+
+	if !authorizeCampaign(c, req.Campaign) {
+		return forbiddenCampaign(c, req.Campaign)
+	}
+
+	if _, err := getMatcher(req.Campaign); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": fmt.Sprintf("Campaign not found: %s", req.Campaign),
+		})
+	}
+
+	session, err := sessionStore.create(req.CallID, req.Campaign, req.Stage)
+	switch err {
+	case errSessionExists:
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": fmt.Sprintf("Session already exists: %s", req.CallID),
+		})
+	case errTooManySessions:
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"error": "Too many active sessions, try again later",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, session)
+}
+
+func handleGetSession(c echo.Context) error {
+	session, ok := sessionStore.get(c.Param("id"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": fmt.Sprintf("Session not found: %s", c.Param("id")),
+		})
+	}
+	if !authorizeCampaign(c, session.Campaign) {
+		return forbiddenCampaign(c, session.Campaign)
+	}
+
+	return c.JSON(http.StatusOK, session)
+}
+
+// handleSessionMatch matches an utterance in the session's current stage (or the
+// stage given in the request), records the turn and moves the session to the
+// next stage declared by the campaign transitions
+func handleSessionMatch(c echo.Context) error {
+	callID := c.Param("id")
+
+	var req SessionMatchRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	session, ok := sessionStore.get(callID)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": fmt.Sprintf("Session not found: %s", callID),
+		})
+	}
+	if !authorizeCampaign(c, session.Campaign) {
+		return forbiddenCampaign(c, session.Campaign)
+	}
+
+	if req.Stage == "" {
+		req.Stage = session.CurrentStage
+	}
+	matchReq := MatchRequest{Campaign: session.Campaign, SpeechText: req.SpeechText, Stage: req.Stage}
+	if msg := validateMatchRequest(&matchReq); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	matcher, err := getMatcher(session.Campaign)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": fmt.Sprintf("Campaign not found: %s", session.Campaign),
+		})
+	}
+
+	start := time.Now()
+	explanation := matcher.ExplainStage(req.SpeechText, req.Stage)
+	explanation.Campaign = session.Campaign
+	observeMatch(matcher, session.Campaign, req.Stage, req.SpeechText, explanation.toMatchResult(), time.Since(start))
+
+	turn := SessionTurn{
+		Stage:       req.Stage,
+		SpeechText:  req.SpeechText,
+		Result:      explanation.Result,
+		NextStage:   matcher.nextStage(req.Stage, explanation.Result),
+		Explanation: explanation,
+		MatchedAt:   time.Now(),
+	}
+	if _, ok := sessionStore.record(callID, turn); !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": fmt.Sprintf("Session not found: %s", callID),
+		})
+	}
+
+	return c.JSON(http.StatusOK, SessionMatchResponse{
+		CallID:      callID,
+		Result:      turn.Result,
+		Stage:       turn.Stage,
+		Campaign:    session.Campaign,
+		NextStage:   turn.NextStage,
+		Explanation: explanation,
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newTestSessionServer serves the session API over a campaign named "campaign"
+// with a fresh session store
+func newTestSessionServer(t *testing.T, ss *SessionStore) *echo.Echo {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "campaign.json"), `{
+		"settings": {"transitions": {"s1": {"busy": "s2", "*": "s1"}}},
+		"busy_p1_s1": ["busy"],
+		"interested_p1_s2": ["interested"]
+	}`)
+	useTestCampaignCache(t, dir)
+
+	previous := sessionStore
+	sessionStore = ss
+	t.Cleanup(func() { sessionStore = previous })
+
+	e := echo.New()
+	e.POST("/sessions", handleCreateSession)
+	e.GET("/sessions/:id", handleGetSession)
+	e.POST("/sessions/:id/match", handleSessionMatch)
+	return e
+}
+
+func TestSessionCreate(t *testing.T) {
+	e := newTestSessionServer(t, NewSessionStore(time.Minute, 10))
+
+	body := `{"call_id": "call-1", "campaign": "campaign"}`
+	if rec := editRequest(e, http.MethodPost, "/sessions", "", body); rec.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s, want 201", rec.Code, rec.Body)
+	}
+	if rec := editRequest(e, http.MethodPost, "/sessions", "", body); rec.Code != http.StatusConflict {
+		t.Errorf("second create: %d %s, want 409", rec.Code, rec.Body)
+	}
+	if rec := editRequest(e, http.MethodPost, "/sessions", "", `{"call_id": "call-2", "campaign": "missing"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("create for an unknown campaign: %d %s, want 404", rec.Code, rec.Body)
+	}
+}
+
+// Each match is recorded and moves the session along the campaign transitions
+func TestSessionMatchTransition(t *testing.T) {
+	e := newTestSessionServer(t, NewSessionStore(time.Minute, 10))
+	editRequest(e, http.MethodPost, "/sessions", "", `{"call_id": "call-1", "campaign": "campaign"}`)
+
+	steps := []struct {
+		text, result, stage, next string
+	}{
+		{"hello", "unknown", "s1", "s1"},
+		{"i am busy", "busy", "s1", "s2"},
+		{"i am interested", "interested", "s2", ""},
+	}
+	for _, step := range steps {
+		rec := editRequest(e, http.MethodPost, "/sessions/call-1/match", "", fmt.Sprintf(`{"speech_text": %q}`, step.text))
+		var resp SessionMatchResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("match %q: %d %s", step.text, rec.Code, rec.Body)
+		}
+		if resp.Result != step.result || resp.Stage != step.stage || resp.NextStage != step.next {
+			t.Errorf("match %q = %s in %s, next %q; want %s in %s, next %q",
+				step.text, resp.Result, resp.Stage, resp.NextStage, step.result, step.stage, step.next)
+		}
+	}
+
+	rec := editRequest(e, http.MethodGet, "/sessions/call-1", "", "")
+	var session Session
+	if err := json.Unmarshal(rec.Body.Bytes(), &session); err != nil {
+		t.Fatal(err)
+	}
+	if session.CurrentStage != "s2" || len(session.Turns) != 3 || session.TurnCount != 3 {
+		t.Errorf("session in %s with %d turns (count %d), want s2 with 3", session.CurrentStage, len(session.Turns), session.TurnCount)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	ss := NewSessionStore(time.Minute, 10)
+	if _, err := ss.create("call-1", "campaign", "s1"); err != nil {
+		t.Fatal(err)
+	}
+	ss.sessions["call-1"].ExpiresAt = time.Now().Add(-time.Second)
+
+	if _, ok := ss.get("call-1"); ok {
+		t.Errorf("expired session is still served")
+	}
+	if _, ok := ss.sessions["call-1"]; ok {
+		t.Errorf("expired session is still stored after a lookup")
+	}
+	// An expired call ID can start a new session
+	if _, err := ss.create("call-1", "campaign", "s1"); err != nil {
+		t.Errorf("create after expiry: %v", err)
+	}
+}
+
+func TestSessionLimits(t *testing.T) {
+	ss := NewSessionStore(time.Minute, 2)
+	ss.create("call-1", "campaign", "s1")
+	ss.create("call-2", "campaign", "s1")
+	if _, err := ss.create("call-3", "campaign", "s1"); err != errTooManySessions {
+		t.Errorf("create in a full store: %v, want %v", err, errTooManySessions)
+	}
+	// Expired sessions make room even before the sweep
+	ss.sessions["call-1"].ExpiresAt = time.Now().Add(-time.Second)
+	if _, err := ss.create("call-3", "campaign", "s1"); err != nil {
+		t.Errorf("create after an expiry: %v", err)
+	}
+
+	for i := 0; i < maxSessionTurns+5; i++ {
+		ss.record("call-2", SessionTurn{SpeechText: fmt.Sprint(i), MatchedAt: time.Now()})
+	}
+	session, _ := ss.get("call-2")
+	if len(session.Turns) != maxSessionTurns || session.TurnCount != maxSessionTurns+5 {
+		t.Fatalf("kept %d turns (count %d), want %d (count %d)", len(session.Turns), session.TurnCount, maxSessionTurns, maxSessionTurns+5)
+	}
+	if first := session.Turns[0].SpeechText; first != "5" {
+		t.Errorf("first kept turn = %s, want 5", first)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// settingsKey is the reserved top-level key holding campaign settings
+// It is never parsed as a category
+const settingsKey = "settings"
+
+// Match modes a category can opt into in addition to literal matching
+const (
+	matchModeFuzzy    = "fuzzy"
+	matchModePhonetic = "phonetic"
+	matchModePattern  = "pattern" // Wildcard, optional word and regex keywords (see compileKeywordPattern)
+)
+
+// CampaignSettings holds optional per-campaign matching configuration
+// Example:
+//
+//	"settings": {
+//	  "match_modes": {"interested_p6_s1": ["fuzzy"], "notInterested_p8_s2": ["phonetic"], "busy_p10_s2": ["pattern"]},
+//	  "fuzzy": {"max_distance": 2, "chars_per_edit": 4},
+//	  "negation": {
+//	    "negators": ["not", "never", "do not"],
+//	    "window": 3,
+//	    "categories": {"interested_p6_s1": {"action": "reroute", "opposite": "notFeelingGood_p4_s1"}}
+//	  },
+//	  "transitions": {"s1": {"interested": "s2", "*": "s1"}},
+//	  "numbers": {"enabled": true},
+//	  "disfluency": {"enabled": true, "fillers": ["um", "uh", "like", "you know"]},
+//	  "confidence": {"threshold": 0.4, "low_result": "lowconfidence"}
+//	}
+type CampaignSettings struct {
+	MatchModes  map[string][]string          `json:"match_modes"` // category key -> extra match modes
+	Fuzzy       FuzzySettings                `json:"fuzzy"`
+	Negation    NegationSettings             `json:"negation"`
+	Transitions map[string]map[string]string `json:"transitions"` // stage -> result -> next stage ("*" matches any result)
+	Numbers     NumberSettings               `json:"numbers"`
+	Disfluency  DisfluencySettings           `json:"disfluency"`
+	Confidence  ConfidenceSettings           `json:"confidence"`
+}
+
+// FuzzySettings controls the edit distance allowed by fuzzy matching
+// A keyword word of n characters tolerates min(MaxDistance, n/CharsPerEdit) edits,
+// so short words like "no" never match fuzzily
+type FuzzySettings struct {
+	MaxDistance  int `json:"max_distance"`
+	CharsPerEdit int `json:"chars_per_edit"`
+}
+
+// NumberSettings controls number canonicalization in normalizeText
+// When enabled, spoken numbers and digit strings in both keywords and speech are
+// rewritten as digits, so "over sixty five" and "over 65" match each other
+type NumberSettings struct {
+	Enabled bool `json:"enabled"`
+}
+
+// DisfluencySettings controls the disfluency filter in normalizeText
+// When enabled, bracketed ASR annotations ("[noise]", "<unk>") and filler words
+// are dropped and immediate repetitions ("no no no") are collapsed, in both
+// keywords and speech. Fillers default to defaultFillers.
+type DisfluencySettings struct {
+	Enabled         bool     `json:"enabled"`
+	Fillers         []string `json:"fillers"`
+	KeepRepeats     bool     `json:"keep_repeats"`     // Do not collapse repetitions
+	KeepAnnotations bool     `json:"keep_annotations"` // Do not drop bracketed annotations
+}
+
+// ConfidenceSettings sets the minimum score of a match (see matchScore)
+// Matches scoring below Threshold answer LowResult instead of their category
+type ConfidenceSettings struct {
+	Threshold float64 `json:"threshold"`  // 0 to 1, 0 disables the threshold
+	LowResult string  `json:"low_result"` // Default: "unknown"
+}
+
+// NegationSettings configures negation-aware matching
+// A hit on a negatable keyword whose first word follows a negator within Window
+// words is suppressed, or rerouted to the opposite category. A bare "no" is not
+// a default negator: it mostly answers the question ("no, i'm interested")
+// rather than negating the words after it.
+type NegationSettings struct {
+	Negators   []string                `json:"negators"`
+	Window     int                     `json:"window"`
+	Categories map[string]NegationRule `json:"categories"` // category key -> rule
+}
+
+// NegationRule marks the keywords of one category as negatable
+type NegationRule struct {
+	Action   string   `json:"action"`   // "suppress" (default) or "reroute"
+	Opposite string   `json:"opposite"` // Category key that receives rerouted hits
+	Keywords []string `json:"keywords"` // Negatable keywords (default: all keywords of the category)
+}
+
+// defaultCampaignSettings returns the settings used when a campaign has none
+func defaultCampaignSettings() CampaignSettings {
+	return CampaignSettings{
+		MatchModes: make(map[string][]string),
+		Fuzzy: FuzzySettings{
+			MaxDistance:  2,
+			CharsPerEdit: 4,
+		},
+		Negation: NegationSettings{
+			Negators: []string{"not", "never", "do not", "cannot"},
+			Window:   3,
+		},
+		Disfluency: DisfluencySettings{
+			Fillers: append([]string(nil), defaultFillers...),
+		},
+		Confidence: ConfidenceSettings{
+			LowResult: "unknown",
+		},
+	}
+}
+
+// parseCampaignSettings decodes the settings value of a campaign file on top of
+// the defaults. On error the defaults are returned along with the error.
+func parseCampaignSettings(value interface{}) (CampaignSettings, error) {
+	settings := defaultCampaignSettings()
+
+	data, err := json.Marshal(value)
+	if err == nil {
+		err = json.Unmarshal(data, &settings)
+	}
+	if err != nil {
+		return defaultCampaignSettings(), err
+	}
+
+	if settings.MatchModes == nil {
+		settings.MatchModes = make(map[string][]string)
+	}
+	if settings.Fuzzy.CharsPerEdit <= 0 {
+		settings.Fuzzy.CharsPerEdit = 4
+	}
+	if settings.Fuzzy.MaxDistance < 0 {
+		settings.Fuzzy.MaxDistance = 0
+	}
+	if settings.Negation.Window <= 0 {
+		settings.Negation.Window = 3
+	}
+	if settings.Confidence.LowResult == "" {
+		settings.Confidence.LowResult = "unknown"
+	}
+
+	return settings, nil
+}
+
+// hasMode reports whether mode is in the list of match modes
+func hasMode(modes []string, mode string) bool {
+	for _, m := range modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// stringListsToInterfaces converts a map of string lists for use with orderedKeys
+func stringListsToInterfaces(m map[string][]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		out[key] = value
+	}
+	return out
+}
+
+// allowedDistance returns the maximum edit distance for a keyword word of n characters
+func (f FuzzySettings) allowedDistance(n int) int {
+	d := n / f.CharsPerEdit
+	if d > f.MaxDistance {
+		d = f.MaxDistance
+	}
+	return d
+}
@@ -8,57 +8,239 @@ package main
 //   - Exact match (entire text matches keyword)
 //   - Phrase match (tokenized n-grams match keyword)
 //   - Substring match (keyword found with word boundaries)
+//   - Ties: longest keyword, then match type, then file order (see findBestMatch)
 //
 // 4. Return "unknown" if no match found
+// The text is normalized once and scanned once by the stage automaton; every
+// priority level is then resolved from the same set of hits.
 // Note: Returns only the lowercased category name without priority or stage suffix
 func (km *KeywordMatcher) ProcessStage(text, stage string) string {
+	result := km.matchStage(text, stage)
+	if result == nil {
+		return "unknown"
+	}
+	return result.returnValue
+}
+
+// matchStage runs the ProcessStage algorithm and returns the winning match, or
+// nil if nothing matched (or the stage does not exist)
+// The match is counted in the keyword stats, so only production matches call it
+// (/match, /match/batch and final stream chunks); explain, labels and sessions
+// use ExplainStage and LabelStage, which do not count.
+func (km *KeywordMatcher) matchStage(text, stage string) *matchResult {
 	// Get stage data
 	stageData, exists := km.stageMap[stage]
 	if !exists {
-		return "unknown"
+		return nil
 	}
 
-	// Step 1: Check hardcoded keywords first (word boundaries only)
-	if len(stageData.Hardcoded) > 0 {
-		result := km.findBestMatch(text, stageData.Hardcoded)
-		if result != nil {
-			return result.returnValue
+	normalized := km.normalizeText(text)
+	hits := stageData.scan(normalized)
+
+	result, _ := km.findStageMatch(normalized, stageData, hits)
+	stageData.countMatch(result, hits)
+	return result
+}
+
+// peekStage is matchStage without counting the winning keyword in the keyword
+// stats
+func (km *KeywordMatcher) peekStage(text, stage string) *matchResult {
+	stageData, exists := km.stageMap[stage]
+	if !exists {
+		return nil
+	}
+
+	normalized := km.normalizeText(text)
+	result, _ := km.findStageMatch(normalized, stageData, stageData.scan(normalized))
+	return result
+}
+
+// ExplainStage runs the same matching as ProcessStage but also reports the
+// winning keyword and every hit in lower priority levels that it shadowed
+// Nothing is counted in the keyword stats.
+func (km *KeywordMatcher) ExplainStage(text, stage string) *MatchExplanation {
+	normalized := km.normalizeText(text)
+	explanation := &MatchExplanation{
+		Result:         "unknown",
+		Stage:          stage,
+		NormalizedText: normalized,
+		Shadowed:       make([]MatchHit, 0),
+	}
+
+	stageData, exists := km.stageMap[stage]
+	if !exists {
+		return explanation
+	}
+
+	hits := stageData.scan(normalized)
+	result, winningGroup := km.findStageMatch(normalized, stageData, hits)
+	if result == nil {
+		return explanation
+	}
+
+	explanation.Result = result.returnValue
+	explanation.Score = result.score
+	explanation.Winner = &MatchHit{
+		Keyword:     result.keyword,
+		MatchType:   result.matchType,
+		Category:    result.category,
+		CategoryKey: result.categoryKey,
+		Priority:    result.priority,
+		Hardcoded:   result.hardcoded,
+		Result:      result.returnValue,
+	}
+
+	// Every hit in a later group was shadowed by the winner
+	for i := winningGroup + 1; i < len(stageData.groups); i++ {
+		for _, hit := range stageData.groupHits(hits, &stageData.groups[i]) {
+			catEntry := stageData.categoryOf(hit)
+			explanation.Shadowed = append(explanation.Shadowed, MatchHit{
+				Keyword:     stageData.keywordOf(hit),
+				MatchType:   hit.matchType(),
+				Category:    catEntry.Info.BaseName,
+				CategoryKey: catEntry.Info.Key,
+				Priority:    catEntry.Info.Priority,
+				Hardcoded:   catEntry.Info.IsHardcoded,
+				Result:      catEntry.Info.ReturnValue,
+			})
 		}
 	}
 
-	// Step 2: Check prioritized categories in order (p1, p2, p3, etc.)
-	// Categories are already sorted by priority in NewKeywordMatcher
-	currentPriority := -1
-	var currentPriorityCategories []CategoryEntry
+	return explanation
+}
 
-	for i, catEntry := range stageData.Prioritized {
-		// Group categories by priority level
-		if catEntry.Info.Priority != currentPriority {
-			// Check previous priority level if we have accumulated categories
-			if len(currentPriorityCategories) > 0 {
-				result := km.findBestMatch(text, currentPriorityCategories)
-				if result != nil {
-					return result.returnValue
-				}
+// LabelStage returns every category of the stage that matched, not just the
+// first priority level with a hit. Labels are ranked like ProcessStage would
+// rank them: by priority level, then within a level by findBestMatch over the
+// categories not ranked yet. The first label is the ProcessStage result.
+// Slower than ProcessStage; nothing is counted in the keyword stats.
+func (km *KeywordMatcher) LabelStage(text, stage string) *MultiLabelResult {
+	labels := &MultiLabelResult{
+		Result: "unknown",
+		Stage:  stage,
+		Labels: make([]CategoryLabel, 0),
+	}
+
+	stageData, exists := km.stageMap[stage]
+	if !exists {
+		return labels
+	}
+
+	normalized := km.normalizeText(text)
+	hits := stageData.scan(normalized)
+
+	var primary *matchResult
+	for i := range stageData.groups {
+		remaining := stageData.groupHits(hits, &stageData.groups[i])
+		for len(remaining) > 0 {
+			best := km.findBestMatch(normalized, stageData, remaining)
+			if best == nil {
+				break
+			}
+			if primary == nil {
+				primary = best
+				km.applyConfidence(stageData, normalized, primary, hits)
 			}
 
-			// Start new priority level
-			currentPriority = catEntry.Info.Priority
-			currentPriorityCategories = []CategoryEntry{catEntry}
-		} else {
-			// Add to current priority level
-			currentPriorityCategories = append(currentPriorityCategories, catEntry)
+			// Take the winning category's hits out of the group
+			label := CategoryLabel{
+				Category:    best.category,
+				CategoryKey: best.categoryKey,
+				Priority:    best.priority,
+				Hardcoded:   best.hardcoded,
+				Result:      stageData.categoryOf(keywordHit{pattern: best.pattern}).Info.ReturnValue,
+				Score:       stageData.matchScore(normalized, best, hits),
+				Keyword:     best.keyword,
+				MatchType:   best.matchType,
+				Keywords:    make([]LabelKeyword, 0),
+			}
+			winner := stageData.categoryOf(keywordHit{pattern: best.pattern})
+			rest := make([]keywordHit, 0, len(remaining))
+			for _, hit := range remaining {
+				if stageData.categoryOf(hit) != winner {
+					rest = append(rest, hit)
+					continue
+				}
+				label.Keywords = append(label.Keywords, LabelKeyword{
+					Keyword:   stageData.keywordOf(hit),
+					MatchType: hit.matchType(),
+				})
+			}
+			labels.Labels = append(labels.Labels, label)
+			remaining = rest
 		}
+	}
 
-		// Check last priority level if we're at the end
-		if i == len(stageData.Prioritized)-1 && len(currentPriorityCategories) > 0 {
-			result := km.findBestMatch(text, currentPriorityCategories)
-			if result != nil {
-				return result.returnValue
-			}
+	if primary != nil {
+		labels.Result = primary.returnValue
+	}
+	return labels
+}
+
+// findStageMatch walks the category groups in order (hardcoded first, then p1, p2,
+// p3, etc.; categories are already sorted by priority in NewKeywordMatcher) and
+// returns the first match together with the index of the group that produced it
+func (km *KeywordMatcher) findStageMatch(normalized string, stageData *StageCategories, hits []keywordHit) (*matchResult, int) {
+	if len(hits) == 0 {
+		return nil, -1
+	}
+
+	for i := range stageData.groups {
+		group := &stageData.groups[i]
+		result := km.findBestMatch(normalized, stageData, stageData.groupHits(hits, group))
+		if result != nil {
+			km.applyConfidence(stageData, normalized, result, hits)
+			return result, i
 		}
 	}
 
-	// Step 3: No match found
-	return "unknown"
+	return nil, -1
+}
+
+// toMatchResult converts the winner of an explanation back to a matchResult;
+// a nil hit (nothing matched) gives nil
+func (h *MatchHit) toMatchResult() *matchResult {
+	if h == nil {
+		return nil
+	}
+	return &matchResult{
+		keyword:     h.Keyword,
+		matchType:   h.MatchType,
+		length:      len(h.Keyword),
+		category:    h.Category,
+		categoryKey: h.CategoryKey,
+		priority:    h.Priority,
+		hardcoded:   h.Hardcoded,
+		returnValue: h.Result,
+	}
+}
+
+// toMatchResult converts the explanation's winner back to a matchResult with its
+// score; nil if nothing matched
+func (e *MatchExplanation) toMatchResult() *matchResult {
+	result := e.Winner.toMatchResult()
+	if result != nil {
+		result.score = e.Score
+	}
+	return result
+}
+
+// primary converts the first label (the ProcessStage result) to a matchResult;
+// nil if nothing matched
+func (l *MultiLabelResult) primary() *matchResult {
+	if len(l.Labels) == 0 {
+		return nil
+	}
+	label := &l.Labels[0]
+	return &matchResult{
+		keyword:     label.Keyword,
+		matchType:   label.MatchType,
+		length:      len(label.Keyword),
+		category:    label.Category,
+		categoryKey: label.CategoryKey,
+		priority:    label.Priority,
+		hardcoded:   label.Hardcoded,
+		returnValue: l.Result,
+		score:       label.Score,
+	}
 }
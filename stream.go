@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// Stream commit policies: when a result sent to the client becomes final
+const (
+	commitFinal = "final" // The first match sent for a final chunk never changes (default)
+	commitFirst = "first" // The first match sent, even for a partial chunk, never changes
+	commitNone  = "none"  // Every change of result is sent
+)
+
+// defaultStreamMaxPriority is the lowest priority level (highest number) whose
+// categories fire on partial chunks; hardcoded categories always do
+const defaultStreamMaxPriority = 1
+
+// streamIdleTimeout closes streams that receive no chunk for this long
+const streamIdleTimeout = 5 * time.Minute
+
+// streamWriteTimeout closes streams whose client stops reading events
+const streamWriteTimeout = 10 * time.Second
+
+// maxStreamMessageBytes closes streams that send a larger chunk
+const maxStreamMessageBytes = 64 << 10
+
+// streamWindowWords is how many words of final chunks a stream keeps matching;
+// older words are dropped so the cost of a chunk does not grow with the call.
+// maxPhraseWords more are kept so a keyword ending inside the window still
+// matches in full.
+const streamWindowWords = 200
+
+// streamAllowedOrigins are the cross-origin browser pages that may open a
+// stream (CORS_ALLOW_ORIGINS, "*" for any); see checkStreamOrigin
+var streamAllowedOrigins []string
+
+var streamUpgrader = websocket.Upgrader{
+	CheckOrigin: checkStreamOrigin,
+}
+
+// StreamChunk is a transcript message sent by the client
+// Partial chunks carry the current hypothesis of the utterance in progress and
+// replace the previous partial; final chunks close it.
+type StreamChunk struct {
+	Type string `json:"type"` // "partial" or "final"
+	Text string `json:"text"`
+}
+
+// StreamEvent is a message sent to the client
+//   - "ready": the stream is open (Campaign, Stage, Commit, MaxPriority)
+//   - "match": a hardcoded or high priority category fired on a partial chunk
+//   - "final": the result of a final chunk, "unknown" if nothing matched
+//   - "error": the chunk was rejected, the stream stays open
+type StreamEvent struct {
+	Type        string  `json:"type"`
+	Campaign    string  `json:"campaign,omitempty"`
+	Stage       string  `json:"stage,omitempty"`
+	Commit      string  `json:"commit,omitempty"`
+	MaxPriority int     `json:"max_priority,omitempty"`
+	Result      string  `json:"result,omitempty"`
+	CategoryKey string  `json:"category_key,omitempty"`
+	Keyword     string  `json:"keyword,omitempty"`
+	MatchType   string  `json:"match_type,omitempty"`
+	Priority    int     `json:"priority,omitempty"`
+	Hardcoded   bool    `json:"hardcoded,omitempty"`
+	Score       float64 `json:"score,omitempty"`     // Confidence of the match (see matchScore)
+	Committed   bool    `json:"committed,omitempty"` // The result will not change for the rest of the stream
+	Text        string  `json:"text,omitempty"`      // Transcript the result was computed on
+	Error       string  `json:"error,omitempty"`
+}
+
+// matchStream is the state of one streaming connection
+// The transcript is the last words of the final chunks (see streamWindowWords)
+// followed by the current partial, and is matched again whenever it changes.
+type matchStream struct {
+	campaign    string
+	stage       string
+	commit      string
+	maxPriority int
+	finals      []string     // Words of the final chunks received so far, oldest dropped
+	sent        *matchResult // Last result sent in a match or final event
+	committed   bool
+}
+
+// handleStream upgrades the request to a WebSocket matching partial transcripts
+//
+//	GET /match/stream?campaign=fe_basic&stage=s1&commit=final&max_priority=1
+//
+// The client sends StreamChunk messages and receives StreamEvent messages.
+func handleStream(c echo.Context) error {
+	campaign, stage := c.QueryParam("campaign"), c.QueryParam("stage")
+	if campaign == "" || stage == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "campaign and stage are required"})
+	}
+	if !strings.HasPrefix(stage, "s") {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid stage format. Must be s1, s2, s3, etc."})
+	}
+
+	commit := c.QueryParam("commit")
+	switch commit {
+	case "":
+		commit = commitFinal
+	case commitFinal, commitFirst, commitNone:
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Invalid commit policy: %s (must be %s, %s or %s)", commit, commitFinal, commitFirst, commitNone),
+		})
+	}
+
+	maxPriority := defaultStreamMaxPriority
+	if value := c.QueryParam("max_priority"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "max_priority must be a non-negative integer"})
+		}
+		maxPriority = n
+	}
+
+	if !authorizeCampaign(c, campaign) {
+		return forbiddenCampaign(c, campaign)
+	}
+	if _, err := getMatcher(campaign); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": fmt.Sprintf("Campaign not found: %s", campaign),
+		})
+	}
+
+	stream := &matchStream{
+		campaign:    campaign,
+		stage:       stage,
+		commit:      commit,
+		maxPriority: maxPriority,
+	}
+
+	// The upgrader has already answered when it fails
+	ws, err := streamUpgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return nil
+	}
+	defer ws.Close()
+	ws.SetReadLimit(maxStreamMessageBytes)
+	stream.serve(ws)
+	return nil
+}
+
+// checkStreamOrigin accepts clients that send no Origin (ASR servers usually do
+// not), same-origin pages and the origins of CORS_ALLOW_ORIGINS, so a page on
+// another site cannot open a stream with a browser's credentials
+func checkStreamOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range streamAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// serve reads chunks until the client closes the stream, goes idle or sends
+// a chunk over maxStreamMessageBytes
+func (s *matchStream) serve(ws *websocket.Conn) {
+	ready := &StreamEvent{Type: "ready", Campaign: s.campaign, Stage: s.stage, Commit: s.commit, MaxPriority: s.maxPriority}
+	if s.send(ws, ready) != nil {
+		return
+	}
+
+	for {
+		ws.SetReadDeadline(time.Now().Add(streamIdleTimeout))
+
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var event *StreamEvent
+		var chunk StreamChunk
+		if err := json.Unmarshal(message, &chunk); err != nil {
+			event = &StreamEvent{Type: "error", Error: "Invalid chunk"}
+		} else if chunk.Type != "partial" && chunk.Type != "final" {
+			event = &StreamEvent{Type: "error", Error: fmt.Sprintf("Invalid chunk type: %q (must be partial or final)", chunk.Type)}
+		} else if matcher, err := getMatcher(s.campaign); err != nil {
+			event = &StreamEvent{Type: "error", Error: fmt.Sprintf("Campaign not found: %s", s.campaign)}
+		} else {
+			event = s.process(matcher, chunk)
+		}
+
+		if event != nil && s.send(ws, event) != nil {
+			return
+		}
+	}
+}
+
+func (s *matchStream) send(ws *websocket.Conn, event *StreamEvent) error {
+	ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	return ws.WriteJSON(event)
+}
+
+// process matches the transcript after chunk and returns the event to send, or
+// nil when the client does not need to hear about it
+func (s *matchStream) process(matcher *KeywordMatcher, chunk StreamChunk) *StreamEvent {
+	final := chunk.Type == "final"
+	text := s.transcript(chunk.Text)
+	if final {
+		s.finals = append(s.finals, strings.Fields(chunk.Text)...)
+		if keep := streamWindowWords + maxPhraseWords; len(s.finals) > keep {
+			s.finals = append(s.finals[:0], s.finals[len(s.finals)-keep:]...)
+		}
+	}
+
+	if s.committed {
+		if final {
+			return s.event("final", s.sent, text)
+		}
+		return nil
+	}
+
+	if !final {
+		// Partials only fire for decisive categories, once per result
+		result := matcher.peekStage(text, s.stage)
+		if result == nil || !s.fires(result) || sameResult(result, s.sent) {
+			return nil
+		}
+		s.sent = result
+		s.committed = s.commit == commitFirst
+		return s.event("match", result, text)
+	}
+
+	start := time.Now()
+	result := matcher.matchStage(text, s.stage)
+	observeMatch(matcher, s.campaign, s.stage, text, result, time.Since(start))
+	captureMatch(matcher, s.campaign, text, s.stage, result)
+
+	s.sent = result
+	s.committed = result != nil && !result.lowConfidence && s.commit != commitNone
+	return s.event("final", result, text)
+}
+
+// transcript returns the kept words of the final chunks followed by partial
+func (s *matchStream) transcript(partial string) string {
+	return strings.TrimSpace(strings.Join(s.finals, " ") + " " + partial)
+}
+
+// fires reports whether a match on a partial chunk is decisive enough to send
+// Matches below the campaign's confidence threshold never are.
+func (s *matchStream) fires(result *matchResult) bool {
+	return !result.lowConfidence && (result.hardcoded || result.priority <= s.maxPriority)
+}
+
+// event builds a match or final event for result (nil: nothing matched)
+func (s *matchStream) event(eventType string, result *matchResult, text string) *StreamEvent {
+	event := &StreamEvent{
+		Type:      eventType,
+		Campaign:  s.campaign,
+		Stage:     s.stage,
+		Result:    "unknown",
+		Committed: s.committed,
+		Text:      text,
+	}
+	if result != nil {
+		event.Result = result.returnValue
+		event.CategoryKey = result.categoryKey
+		event.Keyword = result.keyword
+		event.MatchType = result.matchType
+		event.Priority = result.priority
+		event.Hardcoded = result.hardcoded
+		event.Score = result.score
+	}
+	return event
+}
+
+// sameResult reports whether two results name the same category
+func sameResult(a, b *matchResult) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.categoryKey == b.categoryKey
+}
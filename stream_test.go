@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+const testStreamCampaign = `{
+	"doNotCall_hardcoded_s1": ["do not call"],
+	"busy_p1_s1": ["busy"],
+	"interested_p2_s1": ["interested"]
+}`
+
+// newTestStreamServer serves /match/stream over a campaign named "campaign"
+func newTestStreamServer(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "campaign.json"), testStreamCampaign)
+	useTestCampaignCache(t, dir)
+
+	e := echo.New()
+	e.GET("/match/stream", handleStream)
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/match/stream?campaign=campaign&stage=s1"
+}
+
+// readEvent reads the next event of a stream
+func readEvent(t *testing.T, ws *websocket.Conn) StreamEvent {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event StreamEvent
+	if err := ws.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	ws, _, err := websocket.DefaultDialer.Dial(newTestStreamServer(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	if event := readEvent(t, ws); event.Type != "ready" || event.Commit != commitFinal || event.MaxPriority != 1 {
+		t.Fatalf("first event = %+v, want ready with the default commit policy", event)
+	}
+
+	// A priority 2 hit on a partial is not decisive and sends nothing, so the
+	// next event is the busy match of the following partial
+	ws.WriteJSON(StreamChunk{Type: "partial", Text: "i am interested"})
+	ws.WriteJSON(StreamChunk{Type: "partial", Text: "i am busy"})
+	if event := readEvent(t, ws); event.Type != "match" || event.Result != "busy" || event.Committed {
+		t.Errorf("partial event = %+v, want an uncommitted busy match", event)
+	}
+
+	ws.WriteJSON(StreamChunk{Type: "final", Text: "i am busy right now"})
+	if event := readEvent(t, ws); event.Type != "final" || event.Result != "busy" || !event.Committed || event.Text != "i am busy right now" {
+		t.Errorf("final event = %+v, want a committed busy result", event)
+	}
+
+	// Committed results do not change
+	ws.WriteJSON(StreamChunk{Type: "final", Text: "do not call me"})
+	if event := readEvent(t, ws); event.Type != "final" || event.Result != "busy" {
+		t.Errorf("final event after commit = %+v, want busy", event)
+	}
+
+	ws.WriteMessage(websocket.TextMessage, []byte("not json"))
+	if event := readEvent(t, ws); event.Type != "error" {
+		t.Errorf("invalid chunk event = %+v, want an error", event)
+	}
+	ws.WriteJSON(StreamChunk{Type: "transcript", Text: "busy"})
+	if event := readEvent(t, ws); event.Type != "error" || !strings.Contains(event.Error, "chunk type") {
+		t.Errorf("unknown chunk type event = %+v, want an error", event)
+	}
+}
+
+// Browser pages from other sites cannot open a stream; other clients can
+func TestStreamOrigin(t *testing.T) {
+	url := newTestStreamServer(t)
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin stream: err %v, want a 403 handshake", err)
+	}
+
+	defer func(previous []string) { streamAllowedOrigins = previous }(streamAllowedOrigins)
+	streamAllowedOrigins = []string{"https://agent.example"}
+	ws, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://agent.example"}})
+	if err != nil {
+		t.Fatalf("allowed origin: %v", err)
+	}
+	ws.Close()
+}
+
+func TestStreamMessageLimit(t *testing.T) {
+	ws, _, err := websocket.DefaultDialer.Dial(newTestStreamServer(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	readEvent(t, ws)
+
+	ws.WriteMessage(websocket.TextMessage, []byte(`{"type": "partial", "text": "`+strings.Repeat("a ", maxStreamMessageBytes)+`"}`))
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := ws.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("read after an oversized chunk: %v, want close %d", err, websocket.CloseMessageTooBig)
+	}
+}
+
+// Long streams keep a bounded window of final words
+func TestStreamWindow(t *testing.T) {
+	km := newTestMatcher(t, testStreamCampaign)
+	s := &matchStream{campaign: "test", stage: "s1", commit: commitNone, maxPriority: 1}
+
+	for i := 0; i < 1000; i++ {
+		if event := s.process(km, StreamChunk{Type: "final", Text: "hello there"}); event.Result != "unknown" {
+			t.Fatalf("final %d = %+v, want unknown", i, event)
+		}
+	}
+	if keep := streamWindowWords + maxPhraseWords; len(s.finals) != keep {
+		t.Errorf("kept %d final words, want %d", len(s.finals), keep)
+	}
+	if event := s.process(km, StreamChunk{Type: "final", Text: "do not"}); event.Result != "unknown" {
+		t.Errorf("final = %+v, want unknown", event)
+	}
+	if event := s.process(km, StreamChunk{Type: "partial", Text: "call me"}); event == nil || event.Result != "donotcall" {
+		t.Errorf("keyword spanning the last final and the partial = %+v, want donotcall", event)
+	}
+}
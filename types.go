@@ -2,6 +2,7 @@ package main
 
 import (
 	"regexp"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,29 +13,88 @@ type FlexibleKeywordSets map[string]interface{}
 // Categories follow the pattern: {category}_{priority}_{stage}
 // Example: "donotcall_p1_s3" or "honeypot_hardcoded_s2"
 type CategoryInfo struct {
+	Key         string // Full category key from the JSON file, e.g., "donotcall_p1_s3"
 	BaseName    string // e.g., "donotcall", "honeypot"
 	Priority    int    // e.g., 1, 2, 3 (0 for hardcoded)
 	Stage       string // e.g., "s1", "s2", "s3"
 	IsHardcoded bool   // true if priority is "hardcoded"
 	ReturnValue string // what to return when matched
+	Description string // Free text from version 2 campaign files
 }
 
-// keywordEntry stores both the keyword and its precompiled regex
+// keywordEntry stores a normalized keyword
 type keywordEntry struct {
-	raw   string
-	regex *regexp.Regexp
+	raw       string
+	words     int            // Number of words, used to decide whether the keyword can be an n-gram token
+	phonetic  string         // Metaphone code of each word, space separated
+	source    int            // Index of the keyword in the campaign file's list
+	regex     *regexp.Regexp // Pattern keyword, matched outside the automaton (nil for literal keywords)
+	resume    *regexp.Regexp // regex for searches resuming inside the text (see findPattern)
+	wildcards bool           // The capturing groups of regex are standalone "*" (see wildcardWordsExpr)
+
+	negatable   bool // Hits inside a negation scope are dropped
+	negatedOnly bool // Rerouted copy: only hits inside a negation scope count
 }
 
 // StageCategories groups categories by stage and priority
 type StageCategories struct {
 	Hardcoded   []CategoryEntry // Checked first, word boundaries only
 	Prioritized []CategoryEntry // Checked in priority order (p1, p2, p3...)
+
+	index            *ahoCorasick      // Every keyword of the stage compiled into one automaton
+	fuzzy            *fuzzyIndex       // Keywords of fuzzy-enabled categories (nil if none)
+	phonetic         *ahoCorasick      // Phonetic keys of phonetic-enabled categories (nil if none)
+	phoneticPatterns []int             // Phonetic automaton pattern id -> stage pattern id
+	negation         *negationDetector // Set when the stage has negatable keywords
+	patterns         []patternRef      // Automaton pattern id -> group/category/keyword
+	groups           []categoryGroup   // Hardcoded group first, then one group per priority level
+	keywordHits      []atomic.Uint64   // Pattern id -> number of matches the keyword won (see keywordStats)
+	keywordShadowed  []atomic.Uint64   // Pattern id -> number of matches the keyword was found in but lost
+	regexPatterns    []int             // Pattern ids of pattern keywords, matched by their regex
+}
+
+// categoryGroup is a set of categories that compete with each other in findBestMatch
+// (all hardcoded categories, or all categories sharing one priority level)
+type categoryGroup struct {
+	categories   []CategoryEntry
+	hardcoded    bool
+	priority     int
+	firstPattern int // First automaton pattern id of the group
+	endPattern   int // One past the last automaton pattern id of the group
+}
+
+// patternRef locates an automaton pattern inside the stage
+type patternRef struct {
+	group    int
+	category int  // Index into categoryGroup.categories
+	keyword  int  // Index into CategoryEntry.Keywords (or Excludes)
+	exclude  bool // Pattern is an exclude keyword
+}
+
+// keywordHit aggregates every occurrence of one automaton pattern in the normalized text
+type keywordHit struct {
+	pattern   int
+	exact     bool // Keyword equals the whole text
+	phrase    bool // Keyword equals one of the tokenized n-grams
+	substring bool // Keyword found with word boundaries
+	fuzzy     bool // Keyword words found within the allowed edit distance
+	distance  int  // Total edit distance of the fuzzy hit
+	phonetic  bool // Keyword words sound like consecutive words of the text
+
+	span    string // Text matched by a pattern keyword with the longest literal ("" for literal keywords)
+	literal int    // Length of span less the words matched by standalone "*"
 }
 
 // CategoryEntry links a category to its keywords
 type CategoryEntry struct {
 	Info     CategoryInfo
 	Keywords []keywordEntry
+	Excludes []keywordEntry // Any hit on these disqualifies the category for the text
+
+	keywordsPath string // JSON path of the keyword list in the campaign file
+	excludesPath string // JSON path of the exclude list in the campaign file
+	Fuzzy        bool   // Opted into fuzzy matching via settings.match_modes
+	Phonetic     bool   // Opted into phonetic matching via settings.match_modes
 }
 
 // KeywordMatcher handles keyword matching for a specific campaign
@@ -42,8 +102,20 @@ type KeywordMatcher struct {
 	// Map of stage -> StageCategories
 	stageMap     map[string]*StageCategories
 	contractions map[string]string
+	fillers      [][]string // Disfluency fillers as word lists, longest first (see prepareFillers)
+	settings     CampaignSettings
+	warnings     []loadWarning // Problems found while loading the campaign file
 	loadedAt     time.Time
 	filePath     string
+	version      string               // Short content hash of the campaign file and its dependencies
+	dependencies map[string]time.Time // Base campaigns and libraries merged in (see resolveCampaign)
+	statsSince   atomic.Int64         // Unix nanoseconds when the keyword counters started (0: at loadedAt)
+}
+
+// loadWarning is a problem found in a campaign file while building the matcher
+type loadWarning struct {
+	path    string // JSON path inside the file, e.g. "interested_p6_s1/3"
+	message string
 }
 
 // Request/Response structures
@@ -51,25 +123,118 @@ type MatchRequest struct {
 	Campaign   string `json:"campaign" form:"campaign" query:"campaign"`
 	SpeechText string `json:"speech_text" form:"speech_text" query:"speech_text"`
 	Stage      string `json:"stage" form:"stage" query:"stage"` // Now accepts s1, s2, s3, etc.
+
+	// N-best ASR hypotheses, matched instead of speech_text (see matchAlternatives)
+	Alternatives      []Alternative `json:"alternatives,omitempty"`
+	Policy            string        `json:"policy,omitempty" query:"policy"`                           // "priority" (default), "vote" or "agree"
+	MinWordConfidence float64       `json:"min_word_confidence,omitempty" query:"min_word_confidence"` // Lower-confidence words never match hardcoded categories
 }
 
 type MatchResponse struct {
-	Result   string `json:"result"`
+	Result   string  `json:"result"`
+	Stage    string  `json:"stage"`
+	Campaign string  `json:"campaign"`
+	Score    float64 `json:"score"` // Confidence of the match, 0 for "unknown" (see matchScore)
+
+	// Set for requests with alternatives
+	Policy       string              `json:"policy,omitempty"`
+	Alternative  *int                `json:"alternative,omitempty"` // Index of the alternative that produced the result
+	Alternatives []AlternativeResult `json:"alternatives,omitempty"`
+}
+
+// BatchMatchResult is the outcome of one item of a /match/batch request
+// Exactly one of Result or Error is set
+type BatchMatchResult struct {
+	Index    int    `json:"index"`
+	Result   string `json:"result,omitempty"`
 	Stage    string `json:"stage"`
 	Campaign string `json:"campaign"`
+	Error    string `json:"error,omitempty"`
+
+	Score       float64 `json:"score"`                 // Confidence of the match, 0 for "unknown" and errors (see matchScore)
+	Alternative *int    `json:"alternative,omitempty"` // Index of the alternative that produced the result
+}
+
+type BatchMatchResponse struct {
+	Results []BatchMatchResult `json:"results"`
+}
+
+// MatchExplanation is returned by /match/explain and shows why a result was chosen
+type MatchExplanation struct {
+	Result         string     `json:"result"`
+	Stage          string     `json:"stage"`
+	Campaign       string     `json:"campaign"`
+	NormalizedText string     `json:"normalized_text"`
+	Score          float64    `json:"score"`
+	Winner         *MatchHit  `json:"winner,omitempty"`
+	Shadowed       []MatchHit `json:"shadowed"` // Hits in lower priority levels that lost to the winner
+}
+
+// MatchHit describes one keyword hit inside a MatchExplanation
+type MatchHit struct {
+	Keyword     string `json:"keyword"`
+	MatchType   string `json:"match_type"`
+	Category    string `json:"category"`
+	CategoryKey string `json:"category_key"`
+	Priority    int    `json:"priority"`
+	Hardcoded   bool   `json:"hardcoded"`
+	Result      string `json:"result"`
+}
+
+// MultiLabelResult is returned by /match/labels and lists every category of the
+// stage that matched, in ranked order; Result is the same as for /match
+type MultiLabelResult struct {
+	Result   string          `json:"result"`
+	Stage    string          `json:"stage"`
+	Campaign string          `json:"campaign"`
+	Labels   []CategoryLabel `json:"labels"`
+}
+
+// CategoryLabel is one matching category inside a MultiLabelResult
+type CategoryLabel struct {
+	Category    string         `json:"category"`
+	CategoryKey string         `json:"category_key"`
+	Priority    int            `json:"priority"`
+	Hardcoded   bool           `json:"hardcoded"`
+	Result      string         `json:"result"`
+	Score       float64        `json:"score"`      // Confidence of the best keyword (see matchScore)
+	Keyword     string         `json:"keyword"`    // Best keyword, as chosen by findBestMatch
+	MatchType   string         `json:"match_type"` // Match type of the best keyword
+	Keywords    []LabelKeyword `json:"keywords"`   // Every matched keyword of the category, in file order
+}
+
+// LabelKeyword is one matched keyword of a CategoryLabel
+type LabelKeyword struct {
+	Keyword   string `json:"keyword"`
+	MatchType string `json:"match_type"`
 }
 
 type ReloadResponse struct {
-	Message    string    `json:"message"`
-	Campaign   string    `json:"campaign,omitempty"`
-	ReloadedAt time.Time `json:"reloaded_at"`
+	Message    string            `json:"message"`
+	Campaign   string            `json:"campaign,omitempty"`
+	ReloadedAt time.Time         `json:"reloaded_at"`
+	Errors     map[string]string `json:"errors,omitempty"` // campaign -> reload error
+}
+
+// ReloadError records a failed campaign reload
+type ReloadError struct {
+	Error       string    `json:"error"`
+	FailedAt    time.Time `json:"failed_at"`
+	KeptVersion bool      `json:"kept_previous_version"` // The previous matcher is still serving
 }
 
 // matchResult stores information about a keyword match
 type matchResult struct {
 	keyword     string
-	matchType   string // "exact", "phrase", "substring"
+	matchType   string // "exact", "phrase", "substring", "fuzzy", "phonetic"
 	length      int
+	pattern     int // Stage pattern id of the winning keyword
 	category    string
+	categoryKey string // Full JSON key, e.g., "answerMachine_p1_s1"
+	priority    int
+	hardcoded   bool
 	returnValue string
+
+	score         float64 // Confidence of the match (see matchScore)
+	lowConfidence bool    // Score below the campaign threshold; returnValue is the low-confidence result
 }